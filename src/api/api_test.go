@@ -0,0 +1,392 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	ctrl "minitwit/controllers"
+)
+
+const testSimAuth = "test-sim-auth"
+
+// request sends a simulator request to an API served from stores and returns
+// the status and body of the response.
+func request(t *testing.T, stores ctrl.Stores, method, path, body string) (int, string) {
+	t.Helper()
+	return requestAs(t, stores, testSimAuth, method, path, body)
+}
+
+// requestAs is request with the given Authorization header.
+func requestAs(t *testing.T, stores ctrl.Stores, auth, method, path, body string) (int, string) {
+	t.Helper()
+	rec := serve(t, stores, auth, method, path, body)
+	resp, _ := io.ReadAll(rec.Result().Body)
+	return rec.Code, string(resp)
+}
+
+func serve(t *testing.T, stores ctrl.Stores, auth, method, path, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	t.Setenv("SIM_AUTH", testSimAuth)
+
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Authorization", auth)
+	rec := httptest.NewRecorder()
+	NewAPI(stores).Router().ServeHTTP(rec, req)
+	return rec
+}
+
+func basicAuth(username, password string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password))
+}
+
+// createToken issues a token with scopes to a user registered by registerUser
+// and returns the Authorization header that uses it.
+func createToken(t *testing.T, stores ctrl.Stores, username string, scopes ...string) string {
+	t.Helper()
+
+	body, _ := json.Marshal(map[string]interface{}{"name": "test", "scopes": scopes})
+	status, resp := requestAs(t, stores, basicAuth(username, "secret"), "POST", "/api/tokens", string(body))
+
+	if status != 201 {
+		t.Fatalf("creating a token: status %d: %s", status, resp)
+	}
+
+	var token struct{ Token string }
+
+	if err := json.Unmarshal([]byte(resp), &token); err != nil {
+		t.Fatal(err)
+	}
+
+	return "Bearer " + token.Token
+}
+
+func registerUser(t *testing.T, stores ctrl.Stores, username string) {
+	t.Helper()
+
+	body := `{"username": "` + username + `", "email": "` + username + `@example.com", "pwd": "secret"}`
+
+	if status, resp := request(t, stores, "POST", "/api/register", body); status != 204 {
+		t.Fatalf("registering %s: status %d: %s", username, status, resp)
+	}
+}
+
+func TestPostAndListMessages(t *testing.T) {
+	stores := ctrl.NewMemoryStores()
+	registerUser(t, stores, "alice")
+
+	if status, _ := request(t, stores, "POST", "/api/msgs/alice", `{"content": "Hello"}`); status != 204 {
+		t.Fatalf("posting: status %d", status)
+	}
+
+	status, body := request(t, stores, "GET", "/api/msgs/alice", "")
+
+	if status != 200 {
+		t.Fatalf("listing: status %d", status)
+	}
+
+	var messages []ctrl.Message
+
+	if err := json.Unmarshal([]byte(body), &messages); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(messages) != 1 || messages[0].Text != "Hello" || messages[0].Author.Username != "alice" {
+		t.Errorf("got %s, want alice's message", body)
+	}
+}
+
+func TestLatestIsKept(t *testing.T) {
+	stores := ctrl.NewMemoryStores()
+	registerUser(t, stores, "alice")
+	request(t, stores, "GET", "/api/msgs?latest=42", "")
+
+	if _, body := request(t, stores, "GET", "/api/latest", ""); !strings.Contains(body, "42") {
+		t.Errorf("got %s, want latest 42", body)
+	}
+}
+
+func TestFollow(t *testing.T) {
+	stores := ctrl.NewMemoryStores()
+	registerUser(t, stores, "alice")
+	registerUser(t, stores, "bob")
+
+	if status, _ := request(t, stores, "POST", "/api/fllws/alice", `{"follow": "bob"}`); status != 204 {
+		t.Fatalf("following: status %d", status)
+	}
+
+	if _, body := request(t, stores, "GET", "/api/fllws/bob", ""); !strings.Contains(body, "alice") {
+		t.Errorf("got %s, want alice among bob's followers", body)
+	}
+
+	if status, _ := request(t, stores, "POST", "/api/fllws/alice", `{"follow": "nobody"}`); status != 404 {
+		t.Errorf("following an unknown user: status %d, want 404", status)
+	}
+
+	if status, _ := request(t, stores, "POST", "/api/fllws/alice", `{}`); status != 400 {
+		t.Errorf("neither following nor unfollowing: status %d, want 400", status)
+	}
+
+	if status, _ := request(t, stores, "PUT", "/api/fllws/alice", `{"follow": "bob"}`); status != 405 {
+		t.Errorf("PUT: status %d, want 405", status)
+	}
+}
+
+func TestUnknownUserIsNotFound(t *testing.T) {
+	if status, _ := request(t, ctrl.NewMemoryStores(), "GET", "/api/msgs/nobody", ""); status != 404 {
+		t.Errorf("status %d, want 404", status)
+	}
+}
+
+func TestGetMessageHasAuthor(t *testing.T) {
+	stores := ctrl.NewMemoryStores()
+	registerUser(t, stores, "alice")
+	request(t, stores, "POST", "/api/msgs/alice", `{"content": "Hello"}`)
+
+	var msg ctrl.Message
+	_, body := requestAs(t, stores, createToken(t, stores, "alice"), "GET", "/api/msgs/alice/1", "")
+
+	if err := json.Unmarshal([]byte(body), &msg); err != nil {
+		t.Fatal(err)
+	}
+
+	if msg.Author.Username != "alice" {
+		t.Errorf("got author %q, want alice", msg.Author.Username)
+	}
+}
+
+func TestTokenLifecycle(t *testing.T) {
+	stores := ctrl.NewMemoryStores()
+	registerUser(t, stores, "alice")
+	auth := createToken(t, stores, "alice", "read")
+
+	// Only the hash of the token is stored
+	tokens, _ := stores.Tokens.GetTokens(1)
+	raw := strings.TrimPrefix(auth, "Bearer ")
+
+	if len(tokens) != 1 || tokens[0].Hash != ctrl.HashToken(raw) || strings.Contains(tokens[0].Hash, raw) {
+		t.Fatalf("got stored tokens %+v, want the hash of %s", tokens, raw)
+	}
+
+	if status, _ := requestAs(t, stores, auth, "GET", "/api/msgs", ""); status != 200 {
+		t.Errorf("using the token: status %d, want 200", status)
+	}
+
+	status, body := requestAs(t, stores, basicAuth("alice", "secret"), "GET", "/api/tokens", "")
+
+	if status != 200 || !strings.Contains(body, `"scopes":["read"]`) || strings.Contains(body, raw) {
+		t.Errorf("listing: status %d: %s, want the token without its secret", status, body)
+	}
+
+	path := fmt.Sprintf("/api/tokens/%d", tokens[0].ID)
+
+	if status, _ := requestAs(t, stores, basicAuth("alice", "secret"), "DELETE", path, ""); status != 204 {
+		t.Errorf("revoking: status %d, want 204", status)
+	}
+
+	if status, _ := requestAs(t, stores, auth, "GET", "/api/msgs", ""); status != 403 {
+		t.Errorf("using a revoked token: status %d, want 403", status)
+	}
+}
+
+func TestTokenManagementNeedsThePassword(t *testing.T) {
+	stores := ctrl.NewMemoryStores()
+	registerUser(t, stores, "alice")
+
+	for _, auth := range []string{basicAuth("alice", "wrong"), basicAuth("nobody", "secret"), createToken(t, stores, "alice", "read")} {
+		if status, _ := requestAs(t, stores, auth, "GET", "/api/tokens", ""); status != 401 {
+			t.Errorf("%s: status %d, want 401", auth, status)
+		}
+	}
+}
+
+func TestTokenScopes(t *testing.T) {
+	stores := ctrl.NewMemoryStores()
+	registerUser(t, stores, "alice")
+	registerUser(t, stores, "bob")
+	request(t, stores, "POST", "/api/msgs/bob", `{"content": "Hello"}`)
+
+	read := createToken(t, stores, "alice", "read")
+	post := createToken(t, stores, "alice", "post")
+	follow := createToken(t, stores, "alice", "follow")
+	moderate := createToken(t, stores, "alice", "moderate")
+
+	tests := []struct {
+		auth, method, path, body string
+		want                     int
+	}{
+		{read, "GET", "/api/msgs/bob", "", 200},
+		{post, "GET", "/api/msgs/bob", "", 403},
+		{read, "POST", "/api/msgs/alice", `{"content": "Hi"}`, 403},
+		{post, "POST", "/api/msgs/alice", `{"content": "Hi"}`, 204},
+		{post, "POST", "/api/msgs/bob", `{"content": "Hi"}`, 403},
+		{post, "POST", "/api/fllws/alice", `{"follow": "bob"}`, 403},
+		{follow, "POST", "/api/fllws/alice", `{"follow": "bob"}`, 204},
+		{follow, "POST", "/api/fllws/bob", `{"follow": "alice"}`, 403},
+		{read, "GET", "/api/moderation/reports", "", 403},
+		// The scope alone does not make a moderator
+		{moderate, "GET", "/api/moderation/reports", "", 403},
+	}
+
+	for _, test := range tests {
+		if status, body := requestAs(t, stores, test.auth, test.method, test.path, test.body); status != test.want {
+			t.Errorf("%s %s with %s: status %d, want %d: %s", test.method, test.path, test.auth, status, test.want, body)
+		}
+	}
+
+	if err := stores.Moderation.SetModerator(1, true); err != nil {
+		t.Fatal(err)
+	}
+
+	if status, _ := requestAs(t, stores, moderate, "GET", "/api/moderation/reports", ""); status != 200 {
+		t.Errorf("moderator: status %d, want 200", status)
+	}
+}
+
+func TestTokenOfUnknownUserIsUnauthorized(t *testing.T) {
+	stores := ctrl.NewMemoryStores()
+	raw, hash, _ := ctrl.NewToken()
+
+	if err := stores.Tokens.CreateToken(&ctrl.Token{UserID: 42, Hash: hash, Scopes: "read"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if status, _ := requestAs(t, stores, "Bearer "+raw, "GET", "/api/msgs", ""); status != 401 {
+		t.Errorf("status %d, want 401", status)
+	}
+}
+
+func TestSimulatorSecret(t *testing.T) {
+	stores := ctrl.NewMemoryStores()
+	registerUser(t, stores, "alice")
+	request(t, stores, "POST", "/api/msgs/alice", `{"content": "Hello"}`)
+
+	// The secret is only good for the endpoints the simulator uses
+	for _, path := range []string{"/api/msgs/alice/1", "/api/search?q=hello", "/api/tokens"} {
+		if status, _ := request(t, stores, "GET", path, ""); status < 400 {
+			t.Errorf("%s: status %d, want the secret rejected", path, status)
+		}
+	}
+
+	if status, _ := requestAs(t, stores, testSimAuth+"x", "GET", "/api/msgs", ""); status != 403 {
+		t.Errorf("wrong secret: status %d, want 403", status)
+	}
+
+	// Without SIM_AUTH the legacy mode is off
+	req := httptest.NewRequest("GET", "/api/msgs", nil)
+	req.Header.Set("Authorization", "")
+	t.Setenv("SIM_AUTH", "")
+	rec := httptest.NewRecorder()
+	NewAPI(stores).Router().ServeHTTP(rec, req)
+
+	if rec.Code != 403 {
+		t.Errorf("empty secret with SIM_AUTH unset: status %d, want 403", rec.Code)
+	}
+}
+
+// TestNextLinksWalkTimeline follows the next links of the public messages
+// and checks that they visit every message once, newest first.
+func TestNextLinksWalkTimeline(t *testing.T) {
+	stores := ctrl.NewMemoryStores()
+	registerUser(t, stores, "alice")
+
+	// Equal dates are ordered by ID
+	for i, date := range []int64{10, 20, 20, 20, 30} {
+		msg := ctrl.Message{AuthorID: 1, Text: fmt.Sprint(i), Date: date}
+
+		if err := stores.Messages.CreateMessage(&msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var got []string
+	path := "/api/msgs?no=2&latest=1"
+
+	for path != "" {
+		rec := serve(t, stores, testSimAuth, "GET", path, "")
+
+		if rec.Code != 200 {
+			t.Fatalf("%s: status %d", path, rec.Code)
+		}
+
+		var messages []ctrl.Message
+
+		if err := json.Unmarshal(rec.Body.Bytes(), &messages); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, m := range messages {
+			got = append(got, m.Text)
+		}
+
+		path = ""
+
+		if link := rec.Header().Get("Link"); link != "" {
+			path = link[strings.Index(link, "<")+1 : strings.Index(link, ">")]
+
+			if strings.Contains(path, "latest") {
+				t.Errorf("next link %s repeats latest", path)
+			}
+		}
+	}
+
+	if strings.Join(got, " ") != "4 3 2 1 0" {
+		t.Errorf("got messages %v, want 4 3 2 1 0", got)
+	}
+
+	if status, _ := request(t, stores, "GET", "/api/msgs?before=bm9wZQ", ""); status != 400 {
+		t.Errorf("malformed cursor: status %d, want 400", status)
+	}
+
+	if status, _ := request(t, stores, "GET", "/api/msgs?no=1000000000", ""); status != 200 {
+		t.Errorf("huge page: status %d, want 200", status)
+	}
+}
+
+func TestSearchIsBounded(t *testing.T) {
+	stores := ctrl.NewMemoryStores()
+	registerUser(t, stores, "alice")
+	auth := createToken(t, stores, "alice", "read")
+
+	if status, _ := requestAs(t, stores, auth, "GET", "/api/search?q=alice&no=1000000000", ""); status != 200 {
+		t.Errorf("huge page: status %d, want 200", status)
+	}
+
+	if status, _ := requestAs(t, stores, auth, "GET", "/api/search?q=alice&offset=9223372036854775807", ""); status != 400 {
+		t.Errorf("huge offset: status %d, want 400", status)
+	}
+}
+
+func TestDismissReports(t *testing.T) {
+	stores := ctrl.NewMemoryStores()
+	registerUser(t, stores, "alice")
+	registerUser(t, stores, "mod")
+	request(t, stores, "POST", "/api/msgs/alice", `{"content": "Hello"}`)
+
+	if err := stores.Moderation.SetModerator(2, true); err != nil {
+		t.Fatal(err)
+	}
+
+	moderate := createToken(t, stores, "mod", "moderate")
+	post := createToken(t, stores, "mod", "post")
+
+	if status, _ := requestAs(t, stores, moderate, "POST", "/api/moderation/msgs/1/dismiss", ""); status != 404 {
+		t.Errorf("dismissing without reports: status %d, want 404", status)
+	}
+
+	if status, _ := requestAs(t, stores, post, "POST", "/api/msgs/alice/1/report", `{"reason": "spam"}`); status != 204 {
+		t.Fatalf("reporting: status %d", status)
+	}
+
+	if status, _ := requestAs(t, stores, moderate, "POST", "/api/moderation/msgs/1/dismiss", ""); status != 204 {
+		t.Errorf("dismissing: status %d, want 204", status)
+	}
+
+	if status, _ := requestAs(t, stores, moderate, "POST", "/api/moderation/msgs/99/dismiss", ""); status != 404 {
+		t.Errorf("dismissing an unknown message: status %d, want 404", status)
+	}
+}
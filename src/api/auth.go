@@ -0,0 +1,254 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	ctrl "minitwit/controllers"
+	"minitwit/logging"
+	mntr "minitwit/monitoring"
+)
+
+// caller is the client an API request is made on behalf of: either a user
+// holding an API token, or the course simulator authenticated by SIM_AUTH on
+// the endpoints it uses.
+type caller struct {
+	user      ctrl.User
+	simulator bool
+}
+
+// actsAs reports whether the caller may act as the given user. The simulator
+// may act as anyone.
+func (c *caller) actsAs(userID uint) bool {
+	return c.simulator || c.user.ID == userID
+}
+
+// dummyPwHash stands in for the password hash of unknown usernames.
+var dummyPwHash, _ = ctrl.HashPw("minitwit-dummy-password")
+
+func forbidden(msg string) *Response {
+	return &Response{
+		Status:   403,
+		ErrorMsg: msg,
+	}
+}
+
+// authorizeSimulator authorizes a request to one of the endpoints the course
+// simulator uses: the message lists, posting and following. Besides tokens,
+// they accept the SIM_AUTH secret. This is a legacy mode that is off unless
+// SIM_AUTH is set. The secret lets its holder act as any user, so it should
+// only be set on deployments that serve the simulator.
+func (a *API) authorizeSimulator(r *http.Request, scope string) (*caller, *Response) {
+	simAuth := os.Getenv("SIM_AUTH")
+
+	if simAuth != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte(simAuth)) == 1 {
+		logging.SetUser(r, "simulator")
+		return &caller{simulator: true}, nil
+	}
+
+	return a.authorize(r, scope)
+}
+
+// authorize authenticates the request by its bearer token and checks that the
+// token was granted scope.
+func (a *API) authorize(r *http.Request, scope string) (*caller, *Response) {
+	header := r.Header.Get("Authorization")
+	raw := strings.TrimPrefix(header, "Bearer ")
+
+	if raw == header || raw == "" {
+		return nil, forbidden("You are not authorized to use this resource!")
+	}
+
+	token, err := a.storesFor(r).Tokens.GetTokenByHash(ctrl.HashToken(raw))
+
+	if errors.Is(err, ctrl.ErrNotFound) {
+		return nil, forbidden("You are not authorized to use this resource!")
+	} else if err != nil {
+		logging.FromRequest(r).Error("Error in database lookup", "func", "authorize", "error", err)
+		return nil, &Response{Status: 500}
+	}
+
+	if !token.HasScope(scope) {
+		return nil, forbidden(fmt.Sprintf("This token does not have the %s scope", scope))
+	}
+
+	user, err := a.storesFor(r).Users.GetUserByID(token.UserID)
+
+	if errors.Is(err, ctrl.ErrNotFound) {
+		return nil, &Response{Status: 401, ErrorMsg: "The user of this token no longer exists"}
+	} else if err != nil {
+		logging.FromRequest(r).Error("Error in database lookup", "func", "authorize", "error", err)
+		return nil, &Response{Status: 500}
+	}
+
+	logging.SetUser(r, user.Username)
+
+	if user.Suspended {
+		return nil, forbidden("This account has been suspended")
+	}
+
+	return &caller{user: user}, nil
+}
+
+// passwordAuth authenticates the request by its basic auth username and
+// password. Tokens are managed this way, so that a leaked token cannot be used
+// to issue new ones.
+func (a *API) passwordAuth(w http.ResponseWriter, r *http.Request) (ctrl.User, bool) {
+	username, password, ok := r.BasicAuth()
+
+	if ok {
+		user, err := a.storesFor(r).Users.GetUserByUsername(username)
+		hash := dummyPwHash
+
+		if err == nil {
+			hash = user.PwHash
+		}
+
+		// The hash is checked even for unknown usernames, so that they are
+		// not told apart by a quicker response.
+		if ctrl.CheckPwHash(password, hash) && err == nil {
+			mntr.LoginSucceeded(mntr.SourceAPI)
+			logging.SetUser(r, user.Username)
+			return user, true
+		} else if err != nil && !errors.Is(err, ctrl.ErrNotFound) {
+			logging.FromRequest(r).Error("Error in database lookup", "func", "passwordAuth", "error", err)
+			w.WriteHeader(500)
+			return user, false
+		}
+
+		mntr.LoginFailed(mntr.SourceAPI)
+	}
+
+	w.Header().Set("WWW-Authenticate", `Basic realm="MiniTwit API"`)
+	writeResponse(w, &Response{
+		Status:   401,
+		ErrorMsg: "Invalid username or password",
+	})
+
+	return ctrl.User{}, false
+}
+
+type tokenResponse struct {
+	ID        uint     `json:"id"`
+	Name      string   `json:"name"`
+	Scopes    []string `json:"scopes"`
+	CreatedAt int64    `json:"created_at"`
+	Token     string   `json:"token,omitempty"`
+}
+
+func newTokenResponse(token ctrl.Token) tokenResponse {
+	return tokenResponse{
+		ID:        token.ID,
+		Name:      token.Name,
+		Scopes:    strings.Fields(token.Scopes),
+		CreatedAt: token.CreatedAt,
+	}
+}
+
+func (a *API) tokens(w http.ResponseWriter, r *http.Request) {
+	user, ok := a.passwordAuth(w, r)
+
+	if !ok {
+		return
+	}
+
+	if r.Method == "GET" {
+		tokens, err := a.storesFor(r).Tokens.GetTokens(user.ID)
+
+		if err != nil {
+			logging.FromRequest(r).Error("Error in database lookup", "func", "tokens", "error", err)
+			w.WriteHeader(500)
+			return
+		}
+
+		responses := []tokenResponse{}
+
+		for _, t := range tokens {
+			responses = append(responses, newTokenResponse(t))
+		}
+
+		response, _ := json.Marshal(responses)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(response)
+		return
+	}
+
+	reqData := struct {
+		Name   string   `json:"name"`
+		Scopes []string `json:"scopes"`
+	}{}
+
+	json.NewDecoder(r.Body).Decode(&reqData)
+
+	if len(reqData.Scopes) == 0 {
+		reqData.Scopes = []string{ctrl.ScopeRead}
+	}
+
+	for _, scope := range reqData.Scopes {
+		if !ctrl.ValidScope(scope) {
+			writeBadRequest(w, fmt.Errorf("unknown scope %q", scope))
+			return
+		}
+	}
+
+	raw, hash, err := ctrl.NewToken()
+
+	if err != nil {
+		logging.FromRequest(r).Error("Error in generating token", "func", "tokens", "error", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	token := ctrl.Token{
+		UserID:    user.ID,
+		Name:      reqData.Name,
+		Hash:      hash,
+		Scopes:    strings.Join(reqData.Scopes, " "),
+		CreatedAt: time.Now().Unix(),
+	}
+
+	if err := a.storesFor(r).Tokens.CreateToken(&token); err != nil {
+		logging.FromRequest(r).Error("Error in creating database record", "func", "tokens", "error", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	resp := newTokenResponse(token)
+	resp.Token = raw
+	response, _ := json.Marshal(resp)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(201)
+	w.Write(response)
+}
+
+func (a *API) revokeToken(w http.ResponseWriter, r *http.Request) {
+	user, ok := a.passwordAuth(w, r)
+
+	if !ok {
+		return
+	}
+
+	id, _ := strconv.ParseUint(mux.Vars(r)["id"], 10, 0)
+	err := a.storesFor(r).Tokens.DeleteToken(user.ID, uint(id))
+
+	if errors.Is(err, ctrl.ErrNotFound) {
+		w.WriteHeader(404)
+		return
+	} else if err != nil {
+		logging.FromRequest(r).Error("Error in deleting database record", "func", "revokeToken", "error", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	w.WriteHeader(204)
+}
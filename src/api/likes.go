@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	ctrl "minitwit/controllers"
+	"minitwit/logging"
+)
+
+// like likes a message on POST and unlikes it on DELETE, as the calling user.
+// Both are idempotent and respond 204.
+func (a *API) like(w http.ResponseWriter, r *http.Request) {
+	a.updateLatest(r)
+	caller, errResponse := a.authorize(r, ctrl.ScopePost)
+
+	if errResponse != nil {
+		writeResponse(w, errResponse)
+		return
+	}
+
+	msg, ok := a.lookupMessage(w, r)
+
+	if !ok {
+		return
+	}
+
+	var err error
+
+	if r.Method == "POST" {
+		err = a.storesFor(r).Likes.Like(caller.user.ID, msg.ID, time.Now().Unix())
+	} else {
+		err = a.storesFor(r).Likes.Unlike(caller.user.ID, msg.ID)
+	}
+
+	if err != nil {
+		logging.FromRequest(r).Error("Error in updating database record", "func", "like", "error", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	w.WriteHeader(204)
+}
+
+// likers lists the users who like a message, latest like first.
+func (a *API) likers(w http.ResponseWriter, r *http.Request) {
+	a.updateLatest(r)
+
+	if _, errResponse := a.authorize(r, ctrl.ScopeRead); errResponse != nil {
+		writeResponse(w, errResponse)
+		return
+	}
+
+	msg, ok := a.lookupMessage(w, r)
+
+	if !ok {
+		return
+	}
+
+	users, err := a.storesFor(r).Likes.GetLikers(msg.ID)
+
+	if err != nil {
+		logging.FromRequest(r).Error("Error in database lookup", "func", "likers", "error", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	if users == nil {
+		users = []ctrl.User{}
+	}
+
+	response, _ := json.Marshal(users)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(response)
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -11,11 +12,13 @@ import (
 	"time"
 
 	"github.com/gorilla/mux"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
-	"gorm.io/gorm"
 
+	"minitwit/config"
 	ctrl "minitwit/controllers"
+	"minitwit/logging"
+	"minitwit/migrations"
 	mntr "minitwit/monitoring"
+	"minitwit/server"
 )
 
 type Response struct {
@@ -23,90 +26,253 @@ type Response struct {
 	ErrorMsg string
 }
 
-var (
-	db     *gorm.DB
-	latest = 0
-)
+// API holds what the API handlers depend on. main serves it from the
+// database, while tests can serve it from the in-memory stores.
+type API struct {
+	stores ctrl.Stores
+}
 
-const (
-	port = 8000
-)
+func NewAPI(stores ctrl.Stores) *API {
+	return &API{stores: stores}
+}
 
 func main() {
-	db = ctrl.ConnectDB()
-	r := mux.NewRouter()
+	logging.SetDefault(logging.New(os.Stderr).With("service", "api"))
 
-	// Endpoints
-	r.HandleFunc("/api/latest", getLatest)
-	r.HandleFunc("/api/register", register)
-	r.HandleFunc("/api/fllws/{username}", follow)
-	r.HandleFunc("/api/msgs/{username}", messagesPerUser)
-	r.HandleFunc("/api/msgs", messages)
-
-	/*
-		Prometheus metrics setup
-	*/
-
-	http.Handle("/metrics", promhttp.Handler())
-
-	// Use goroutine because http.ListenAndServe() is a blocking method
-	go func() {
-		if err := http.ListenAndServe(":2112", nil); err != nil {
-			fmt.Fprintf(os.Stderr, "Error serving for Prometheus: %s\n", err)
+	cfg, err := config.Load()
+
+	if err != nil {
+		logging.Error("Error loading configuration", "error", err)
+		os.Exit(1)
+	}
+
+	shutdownTracing, err := mntr.SetupTracing("minitwit-api", cfg.Tracing)
+
+	if err != nil {
+		logging.Error("Error setting up tracing", "error", err)
+		os.Exit(1)
+	}
+
+	db, err := ctrl.ConnectDB(cfg.DB)
+
+	if err != nil {
+		logging.Error("Error connecting to database", "error", err)
+		os.Exit(1)
+	}
+
+	if err := mntr.InstrumentDB(db, "minitwit"); err != nil {
+		logging.Error("Error instrumenting database", "error", err)
+		os.Exit(1)
+	}
+
+	if cfg.DB.AutoMigrate {
+		if _, err := migrations.Up(db); err != nil {
+			logging.Error("Error applying migrations", "error", err)
 			os.Exit(1)
 		}
-	}()
+	}
+
+	if err := ctrl.SyncSearchIndex(db); err != nil {
+		logging.Error("Error updating the search index", "error", err)
+		os.Exit(1)
+	}
+
+	api := NewAPI(ctrl.NewGormStores(db))
+
+	go mntr.CollectProcessMetrics(15 * time.Second)
+	go mntr.TrackTotals(time.Minute, func(activeSince int64) (mntr.Totals, error) {
+		totals, err := api.stores.Stats.GetTotals(activeSince)
+		return mntr.Totals(totals), err
+	})
+
+	r := api.Router()
+
+	handler := http.NewServeMux()
+	handler.Handle("/", mntr.MiddlewareTracing(r, mntr.MiddlewareAccessLog(r, mntr.MiddlewareMetrics(r, true))))
+
+	// Health checks are served next to the router, so that probes do not
+	// show up in the access log and request metrics.
+	handler.HandleFunc("/healthz", mntr.Healthz)
+	handler.Handle("/readyz", mntr.Readyz(
+		mntr.DatabaseCheck(db),
+		mntr.MigrationsCheck(db),
+	))
 
-	/*
-		Start API server
-	*/
+	logging.Info("MiniTwit API listening", "port", cfg.Server.APIPort, "metrics_port", cfg.Server.MetricsPort)
 
-	// Register r as HTTP handler
-	http.Handle("/", mntr.MiddlewareMetrics(r, true))
+	err = server.Run(time.Duration(cfg.Server.ShutdownTimeout),
+		server.New(cfg.Server.APIPort, handler, cfg.Server),
+		server.New(cfg.Server.MetricsPort, mntr.MetricsHandler(), cfg.Server),
+	)
 
-	srv := &http.Server{
-		Addr:         "0.0.0.0:" + strconv.Itoa(port),
-		WriteTimeout: 10 * time.Second,
-		ReadTimeout:  10 * time.Second,
+	// The servers are drained, so nothing uses the tracer or the database
+	// anymore.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.Server.ShutdownTimeout))
+
+	if err := shutdownTracing(ctx); err != nil {
+		logging.Error("Error flushing traces", "error", err)
 	}
 
-	fmt.Printf("MiniTwit API listening on port %v\n", port)
+	cancel()
+
+	if sqlDB, err := db.DB(); err == nil {
+		sqlDB.Close()
+	}
 
-	if err := srv.ListenAndServe(); err != nil {
-		fmt.Fprintf(os.Stderr, "Error serving on port %v: %s\n", port, err)
+	if err != nil {
+		logging.Error("Error serving", "error", err)
 		os.Exit(1)
 	}
+
+	logging.Info("MiniTwit API stopped")
 }
 
-func notReqFromSimulator(w http.ResponseWriter, r *http.Request) *Response {
-	if r.Header.Get("Authorization") != os.Getenv("SIM_AUTH") {
-		w.Header().Set("Content-Type", "application/json")
-		status := 403
+// Router returns the routes of the API.
+func (a *API) Router() *mux.Router {
+	r := mux.NewRouter()
 
-		return &Response{
-			Status:   status,
-			ErrorMsg: "You are not authorized to use this resource!",
-		}
+	// Endpoints
+	r.HandleFunc("/api/latest", a.getLatest)
+	r.HandleFunc("/api/register", a.register)
+	r.HandleFunc("/api/fllws/{username}", a.follow).Methods("GET", "POST")
+	r.HandleFunc("/api/msgs/{username}", a.messagesPerUser)
+	r.HandleFunc("/api/msgs/{username}/{id:[0-9]+}", a.message).Methods("GET", "PUT", "DELETE")
+	r.HandleFunc("/api/msgs/{username}/{id:[0-9]+}/revisions", a.revisions).Methods("GET")
+	r.HandleFunc("/api/msgs/{username}/{id:[0-9]+}/thread", a.thread).Methods("GET")
+	r.HandleFunc("/api/msgs/{username}/{id:[0-9]+}/report", a.reportMessage).Methods("POST")
+	r.HandleFunc("/api/msgs/{username}/{id:[0-9]+}/like", a.like).Methods("POST", "DELETE")
+	r.HandleFunc("/api/msgs/{username}/{id:[0-9]+}/likes", a.likers).Methods("GET")
+	r.HandleFunc("/api/msgs", a.messages)
+	r.HandleFunc("/api/mentions/{username}", a.mentions).Methods("GET")
+	r.HandleFunc("/api/tags/{name}", a.tagMessages).Methods("GET")
+	r.HandleFunc("/api/trending", a.trendingTags).Methods("GET")
+	r.HandleFunc("/api/search", a.search).Methods("GET")
+	r.HandleFunc("/api/tokens", a.tokens).Methods("GET", "POST")
+	r.HandleFunc("/api/tokens/{id:[0-9]+}", a.revokeToken).Methods("DELETE")
+	r.HandleFunc("/api/moderation/reports", a.openReports).Methods("GET")
+	r.HandleFunc("/api/moderation/flagged", a.flaggedMessages).Methods("GET")
+	r.HandleFunc("/api/moderation/msgs/{id:[0-9]+}/{action:flag|unflag|dismiss}", a.moderateMessage).Methods("POST")
+	r.HandleFunc("/api/moderation/users/{username}/{action:suspend|unsuspend}", a.suspendUser).Methods("POST")
+
+	return r
+}
+
+// storesFor returns the stores bound to the context of r, so that their
+// queries are traced as part of the request.
+func (a *API) storesFor(r *http.Request) ctrl.Stores {
+	return a.stores.WithContext(r.Context())
+}
+
+func (a *API) updateLatest(r *http.Request) {
+	val, err := strconv.Atoi(r.URL.Query().Get("latest"))
+
+	if err != nil {
+		return
 	}
 
-	return nil
+	if err := a.storesFor(r).Latest.UpdateLatest(val); err != nil {
+		logging.FromRequest(r).Error("Error in updating database record", "func", "updateLatest", "error", err)
+	}
 }
 
-func updateLatest(r *http.Request) {
+// maxPageSize caps the no parameter, so that a single request cannot load a
+// whole table.
+const maxPageSize = 1000
+
+// parsePage reads the no, before and after query parameters of a message
+// listing. no defaults to 100 and is capped at maxPageSize. before and after
+// take the opaque cursors handed out in Link headers.
+func parsePage(r *http.Request) (ctrl.Page, error) {
 	params := r.URL.Query()
-	def := -1
-	val := def
+	page := ctrl.Page{Limit: 100}
+
+	if no := params.Get("no"); no != "" {
+		n, err := strconv.Atoi(no)
+
+		if err != nil || n <= 0 {
+			return page, errors.New("no must be a positive integer")
+		}
+
+		if n > maxPageSize {
+			n = maxPageSize
+		}
+
+		page.Limit = n
+	}
+
+	if before := params.Get("before"); before != "" {
+		cursor, err := ctrl.ParseCursor(before)
+
+		if err != nil {
+			return page, err
+		}
 
-	if params.Get("latest") != "" {
-		val, _ = strconv.Atoi(params.Get("latest"))
+		page.Before = &cursor
 	}
 
-	if val != def {
-		latest = val
+	if after := params.Get("after"); after != "" {
+		cursor, err := ctrl.ParseCursor(after)
+
+		if err != nil {
+			return page, err
+		}
+
+		page.After = &cursor
 	}
+
+	if page.Before != nil && page.After != nil {
+		return page, errors.New("before and after cannot be combined")
+	}
+
+	return page, nil
 }
 
-func getLatest(w http.ResponseWriter, r *http.Request) {
+// setNextLink adds a Link header pointing to the page that follows messages,
+// continuing in the direction the client is paging in. It is left out when
+// messages is the last page.
+func setNextLink(w http.ResponseWriter, r *http.Request, page ctrl.Page, messages []ctrl.Message) {
+	if len(messages) == 0 || len(messages) < page.Limit {
+		return
+	}
+
+	params := r.URL.Query()
+	params.Del("latest")
+	params.Del("before")
+	params.Del("after")
+
+	if page.After != nil {
+		params.Set("after", ctrl.CursorOf(messages[0]).String())
+	} else {
+		params.Set("before", ctrl.CursorOf(messages[len(messages)-1]).String())
+	}
+
+	w.Header().Set("Link", fmt.Sprintf("<%s?%s>; rel=\"next\"", r.URL.Path, params.Encode()))
+}
+
+func writeResponse(w http.ResponseWriter, resp *Response) {
+	response, _ := json.Marshal(resp)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(resp.Status)
+	w.Write(response)
+}
+
+func writeBadRequest(w http.ResponseWriter, err error) {
+	writeResponse(w, &Response{
+		Status:   400,
+		ErrorMsg: err.Error(),
+	})
+}
+
+func (a *API) getLatest(w http.ResponseWriter, r *http.Request) {
+	latest, err := a.storesFor(r).Latest.GetLatest()
+
+	if err != nil {
+		logging.FromRequest(r).Error("Error in database lookup", "func", "getLatest", "error", err)
+		w.WriteHeader(500)
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 
 	resp, _ := json.Marshal(struct {
@@ -116,8 +282,8 @@ func getLatest(w http.ResponseWriter, r *http.Request) {
 	w.Write(resp)
 }
 
-func register(w http.ResponseWriter, r *http.Request) {
-	updateLatest(r)
+func (a *API) register(w http.ResponseWriter, r *http.Request) {
+	a.updateLatest(r)
 
 	reqData := struct {
 		Username string `json:"username"`
@@ -140,7 +306,7 @@ func register(w http.ResponseWriter, r *http.Request) {
 		} else if len(reqData.Pwd) == 0 {
 			errorMsg = "You have to enter a password"
 			status = 400
-		} else if ctrl.GetUserID(reqData.Username, db) != 0 {
+		} else if a.storesFor(r).Users.GetUserID(reqData.Username) != 0 {
 			errorMsg = "The username is already taken"
 			status = 400
 		} else {
@@ -148,14 +314,17 @@ func register(w http.ResponseWriter, r *http.Request) {
 			pw, err := ctrl.HashPw(reqData.Pwd)
 
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "register: Error in password hashing: %s\n", err)
+				logging.FromRequest(r).Error("Error in password hashing", "func", "register", "error", err)
+				status = 500
+			} else if err := a.storesFor(r).Users.CreateUser(&ctrl.User{
+				Username: reqData.Username,
+				Email:    reqData.Email,
+				PwHash:   pw,
+			}); err != nil {
+				logging.FromRequest(r).Error("Error in creating database record", "func", "register", "error", err)
 				status = 500
 			} else {
-				db.Create(&ctrl.User{
-					Username: reqData.Username,
-					Email:    reqData.Email,
-					PwHash:   pw,
-				})
+				mntr.UserRegistered(mntr.SourceAPI)
 			}
 		}
 
@@ -174,42 +343,31 @@ func register(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(status)
 }
 
-func messages(w http.ResponseWriter, r *http.Request) {
-	updateLatest(r)
-	notFromSimResponse := notReqFromSimulator(w, r)
+func (a *API) messages(w http.ResponseWriter, r *http.Request) {
+	a.updateLatest(r)
 
-	if notFromSimResponse != nil {
-		response, _ := json.Marshal(notFromSimResponse)
-		w.WriteHeader(notFromSimResponse.Status)
-		w.Write(response)
+	if _, errResponse := a.authorizeSimulator(r, ctrl.ScopeRead); errResponse != nil {
+		writeResponse(w, errResponse)
 		return
 	}
 
 	status := 200
-	def := 100
-	vars := mux.Vars(r)
-	val := def
+	page, err := parsePage(r)
 
-	if len(vars) != 0 {
-		val, _ = strconv.Atoi(vars["no"])
+	if err != nil {
+		writeBadRequest(w, err)
+		return
 	}
 
-	noMsgs := val
-
 	if r.Method == "GET" {
 		w.Header().Set("Content-Type", "application/json")
-		var messages []ctrl.Message
+		messages, err := a.storesFor(r).Messages.GetPublicMessages(page)
 
-		query := db.Limit(noMsgs).
-			Joins("JOIN users ON messages.author_id = users.id").
-			Order("messages.date desc").
-			Where("flagged = ?", 0).
-			Find(&messages)
-
-		if query.Error != nil && !errors.Is(query.Error, gorm.ErrRecordNotFound) {
-			fmt.Fprintf(os.Stderr, "messages: Error in database lookup: %s\n", query.Error)
+		if err != nil {
+			logging.FromRequest(r).Error("Error in database lookup", "func", "messages", "error", err)
 			status = 500
 		} else {
+			setNextLink(w, r, page, messages)
 			response, _ := json.Marshal(messages)
 			w.Write(response)
 		}
@@ -220,27 +378,31 @@ func messages(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(status)
 }
 
-func messagesPerUser(w http.ResponseWriter, r *http.Request) {
-	updateLatest(r)
-	notFromSimResponse := notReqFromSimulator(w, r)
+func (a *API) messagesPerUser(w http.ResponseWriter, r *http.Request) {
+	a.updateLatest(r)
+	scope := ctrl.ScopeRead
 
-	if notFromSimResponse != nil {
-		response, _ := json.Marshal(notFromSimResponse)
-		w.WriteHeader(notFromSimResponse.Status)
-		w.Write(response)
+	if r.Method == "POST" {
+		scope = ctrl.ScopePost
+	}
+
+	caller, errResponse := a.authorizeSimulator(r, scope)
+
+	if errResponse != nil {
+		writeResponse(w, errResponse)
 		return
 	}
 
 	var status int
-	def := 100
 	vars := mux.Vars(r)
-	noMsgs := def
+	page, err := parsePage(r)
 
-	if len(vars) != 0 {
-		noMsgs, _ = strconv.Atoi(vars["no"])
+	if err != nil {
+		writeBadRequest(w, err)
+		return
 	}
 
-	userID := ctrl.GetUserID(vars["username"], db)
+	userID := a.storesFor(r).Users.GetUserID(vars["username"])
 
 	if userID == 0 {
 		w.WriteHeader(404)
@@ -251,38 +413,70 @@ func messagesPerUser(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 		status = 200
 
-		var messages []ctrl.Message
+		messages, err := a.storesFor(r).Messages.GetUserMessages(userID, page)
 
-		query := db.Limit(noMsgs).
-			Joins("JOIN users ON messages.author_id = users.id").
-			Order("messages.date desc").
-			Where(&ctrl.Message{AuthorID: userID, Flagged: 0}).
-			Find(&messages)
-
-		if query.Error != nil && !errors.Is(query.Error, gorm.ErrRecordNotFound) {
-			fmt.Fprintf(os.Stderr, "messagesPerUser: Error in database lookup: %s\n", query.Error)
+		if err != nil {
+			logging.FromRequest(r).Error("Error in database lookup", "func", "messagesPerUser", "error", err)
 			status = 500
+		} else {
+			setNextLink(w, r, page, messages)
+			response, _ := json.Marshal(messages)
+			w.Write(response)
 		}
 	} else if r.Method == "POST" {
+		if !caller.actsAs(userID) {
+			writeResponse(w, forbidden("You can only post messages as yourself"))
+			return
+		}
+
+		author, err := a.storesFor(r).Users.GetUserByID(userID)
+
+		if err != nil {
+			logging.FromRequest(r).Error("Error in database lookup", "func", "messagesPerUser", "error", err)
+			w.WriteHeader(500)
+			return
+		}
+
+		if author.Suspended {
+			writeResponse(w, forbidden("This account has been suspended"))
+			return
+		}
 
 		status = 204
 
 		reqData := struct {
-			Content string `json:"content"`
+			Content   string `json:"content"`
+			InReplyTo uint   `json:"in_reply_to"`
 		}{}
 
 		json.NewDecoder(r.Body).Decode(&reqData)
 
-		query := db.Create(&ctrl.Message{
-			AuthorID: userID,
-			Text:     reqData.Content,
-			Date:     time.Now().Unix(),
-			Flagged:  0,
+		if reqData.InReplyTo != 0 {
+			parent, err := a.storesFor(r).Messages.GetMessage(reqData.InReplyTo)
+
+			if errors.Is(err, ctrl.ErrNotFound) || (err == nil && parent.Flagged != 0) {
+				writeBadRequest(w, errors.New("in_reply_to does not refer to a message"))
+				return
+			} else if err != nil {
+				logging.FromRequest(r).Error("Error in database lookup", "func", "messagesPerUser", "error", err)
+				w.WriteHeader(500)
+				return
+			}
+		}
+
+		err = a.storesFor(r).Messages.CreateMessage(&ctrl.Message{
+			AuthorID:  userID,
+			Text:      reqData.Content,
+			Date:      time.Now().Unix(),
+			Flagged:   0,
+			InReplyTo: reqData.InReplyTo,
 		})
 
-		if query.Error != nil && !errors.Is(query.Error, gorm.ErrRecordNotFound) {
-			fmt.Fprintf(os.Stderr, "messagesPerUser: Error in creating database record: %s\n", query.Error)
+		if err != nil {
+			logging.FromRequest(r).Error("Error in creating database record", "func", "messagesPerUser", "error", err)
 			status = 500
+		} else {
+			mntr.MessagePosted(mntr.SourceAPI)
 		}
 	} else {
 		status = 405 // Method Not Allowed
@@ -291,19 +485,377 @@ func messagesPerUser(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(status)
 }
 
-func follow(w http.ResponseWriter, r *http.Request) {
-	updateLatest(r)
-	notFromSimResponse := notReqFromSimulator(w, r)
+func (a *API) mentions(w http.ResponseWriter, r *http.Request) {
+	a.updateLatest(r)
+
+	if _, errResponse := a.authorize(r, ctrl.ScopeRead); errResponse != nil {
+		writeResponse(w, errResponse)
+		return
+	}
+
+	page, err := parsePage(r)
+
+	if err != nil {
+		writeBadRequest(w, err)
+		return
+	}
+
+	userID := a.storesFor(r).Users.GetUserID(mux.Vars(r)["username"])
+
+	if userID == 0 {
+		w.WriteHeader(404)
+		return
+	}
+
+	messages, err := a.storesFor(r).Messages.GetMentionMessages(userID, page)
+
+	if err != nil {
+		logging.FromRequest(r).Error("Error in database lookup", "func", "mentions", "error", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	if messages == nil {
+		messages = []ctrl.Message{}
+	}
+
+	setNextLink(w, r, page, messages)
+	response, _ := json.Marshal(messages)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(response)
+}
+
+func (a *API) tagMessages(w http.ResponseWriter, r *http.Request) {
+	a.updateLatest(r)
+
+	if _, errResponse := a.authorize(r, ctrl.ScopeRead); errResponse != nil {
+		writeResponse(w, errResponse)
+		return
+	}
+
+	tag := strings.ToLower(mux.Vars(r)["name"])
+
+	if !ctrl.ValidTag(tag) {
+		w.WriteHeader(404)
+		return
+	}
+
+	page, err := parsePage(r)
+
+	if err != nil {
+		writeBadRequest(w, err)
+		return
+	}
+
+	messages, err := a.storesFor(r).Messages.GetTagMessages(tag, page)
+
+	if err != nil {
+		logging.FromRequest(r).Error("Error in database lookup", "func", "tagMessages", "error", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	if messages == nil {
+		messages = []ctrl.Message{}
+	}
+
+	setNextLink(w, r, page, messages)
+	response, _ := json.Marshal(messages)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(response)
+}
 
-	if notFromSimResponse != nil {
-		response, _ := json.Marshal(notFromSimResponse)
-		w.WriteHeader(notFromSimResponse.Status)
+// trendingTags lists the tags used by the most messages within the last
+// ctrl.TrendingWindow. The no parameter sets how many are listed.
+func (a *API) trendingTags(w http.ResponseWriter, r *http.Request) {
+	a.updateLatest(r)
+
+	if _, errResponse := a.authorize(r, ctrl.ScopeRead); errResponse != nil {
+		writeResponse(w, errResponse)
+		return
+	}
+
+	limit := 10
+
+	if no := r.URL.Query().Get("no"); no != "" {
+		n, err := strconv.Atoi(no)
+
+		if err != nil || n <= 0 {
+			writeBadRequest(w, errors.New("no must be a positive integer"))
+			return
+		}
+
+		limit = n
+	}
+
+	since := time.Now().Add(-ctrl.TrendingWindow).Unix()
+	tags, err := a.storesFor(r).Messages.GetTrendingTags(since, limit)
+
+	if err != nil {
+		logging.FromRequest(r).Error("Error in database lookup", "func", "trendingTags", "error", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	if tags == nil {
+		tags = []ctrl.TagCount{}
+	}
+
+	response, _ := json.Marshal(tags)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(response)
+}
+
+type searchResults struct {
+	Users    []ctrl.User    `json:"users"`
+	Messages []ctrl.Message `json:"messages"`
+}
+
+// maxSearchOffset bounds the offset parameter of the search, since deep
+// offsets make the database rank and skip ever more results.
+const maxSearchOffset = 10000
+
+// search finds the users and messages matching the q parameter, most relevant
+// first. The no and offset parameters page through both lists at once; no is
+// capped at maxPageSize and offset at maxSearchOffset.
+func (a *API) search(w http.ResponseWriter, r *http.Request) {
+	a.updateLatest(r)
+
+	if _, errResponse := a.authorize(r, ctrl.ScopeRead); errResponse != nil {
+		writeResponse(w, errResponse)
+		return
+	}
+
+	params := r.URL.Query()
+	limit, offset := 100, 0
+
+	if no := params.Get("no"); no != "" {
+		n, err := strconv.Atoi(no)
+
+		if err != nil || n <= 0 {
+			writeBadRequest(w, errors.New("no must be a positive integer"))
+			return
+		}
+
+		if n > maxPageSize {
+			n = maxPageSize
+		}
+
+		limit = n
+	}
+
+	if o := params.Get("offset"); o != "" {
+		n, err := strconv.Atoi(o)
+
+		if err != nil || n < 0 || n > maxSearchOffset {
+			writeBadRequest(w, fmt.Errorf("offset must be an integer from 0 to %d", maxSearchOffset))
+			return
+		}
+
+		offset = n
+	}
+
+	query := params.Get("q")
+	users, err := a.storesFor(r).Search.SearchUsers(query, limit, offset)
+
+	if err != nil {
+		logging.FromRequest(r).Error("Error in database lookup", "func", "search", "error", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	messages, err := a.storesFor(r).Search.SearchMessages(query, limit, offset)
+
+	if err != nil {
+		logging.FromRequest(r).Error("Error in database lookup", "func", "search", "error", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	results := searchResults{Users: users, Messages: messages}
+
+	if results.Users == nil {
+		results.Users = []ctrl.User{}
+	}
+
+	if results.Messages == nil {
+		results.Messages = []ctrl.Message{}
+	}
+
+	if (len(users) == limit || len(messages) == limit) && offset+limit <= maxSearchOffset {
+		params.Del("latest")
+		params.Set("offset", strconv.Itoa(offset+limit))
+		w.Header().Set("Link", fmt.Sprintf("<%s?%s>; rel=\"next\"", r.URL.Path, params.Encode()))
+	}
+
+	response, _ := json.Marshal(results)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(response)
+}
+
+// lookupMessage finds the message addressed by the request's username and id,
+// writing a 404 if the user has no such visible message.
+func (a *API) lookupMessage(w http.ResponseWriter, r *http.Request) (ctrl.Message, bool) {
+	vars := mux.Vars(r)
+	id, _ := strconv.ParseUint(vars["id"], 10, 0)
+	msg, err := a.storesFor(r).Messages.GetMessage(uint(id))
+
+	if err != nil && !errors.Is(err, ctrl.ErrNotFound) {
+		logging.FromRequest(r).Error("Error in database lookup", "func", "lookupMessage", "error", err)
+		w.WriteHeader(500)
+		return msg, false
+	}
+
+	if err != nil || msg.Flagged != 0 || msg.AuthorID != a.storesFor(r).Users.GetUserID(vars["username"]) {
+		w.WriteHeader(404)
+		return msg, false
+	}
+
+	return msg, true
+}
+
+func (a *API) message(w http.ResponseWriter, r *http.Request) {
+	a.updateLatest(r)
+	scope := ctrl.ScopeRead
+
+	if r.Method != "GET" {
+		scope = ctrl.ScopePost
+	}
+
+	caller, errResponse := a.authorize(r, scope)
+
+	if errResponse != nil {
+		writeResponse(w, errResponse)
+		return
+	}
+
+	msg, ok := a.lookupMessage(w, r)
+
+	if !ok {
+		return
+	}
+
+	if r.Method != "GET" && !caller.actsAs(msg.AuthorID) {
+		writeResponse(w, forbidden("You can only change your own messages"))
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		response, _ := json.Marshal(msg)
+		w.Header().Set("Content-Type", "application/json")
 		w.Write(response)
+	case "PUT":
+		reqData := struct {
+			Content string `json:"content"`
+		}{}
+
+		json.NewDecoder(r.Body).Decode(&reqData)
+
+		if reqData.Content == "" {
+			writeBadRequest(w, errors.New("content cannot be empty"))
+			return
+		}
+
+		if err := a.storesFor(r).Messages.UpdateMessage(msg.ID, reqData.Content, time.Now().Unix()); err != nil {
+			logging.FromRequest(r).Error("Error in updating database record", "func", "message", "error", err)
+			w.WriteHeader(500)
+			return
+		}
+
+		w.WriteHeader(204)
+	case "DELETE":
+		if err := a.storesFor(r).Messages.DeleteMessage(msg.ID); err != nil {
+			logging.FromRequest(r).Error("Error in deleting database record", "func", "message", "error", err)
+			w.WriteHeader(500)
+			return
+		}
+
+		w.WriteHeader(204)
+	}
+}
+
+func (a *API) revisions(w http.ResponseWriter, r *http.Request) {
+	a.updateLatest(r)
+
+	if _, errResponse := a.authorize(r, ctrl.ScopeRead); errResponse != nil {
+		writeResponse(w, errResponse)
+		return
+	}
+
+	msg, ok := a.lookupMessage(w, r)
+
+	if !ok {
+		return
+	}
+
+	revisions, err := a.storesFor(r).Messages.GetRevisions(msg.ID)
+
+	if err != nil {
+		logging.FromRequest(r).Error("Error in database lookup", "func", "revisions", "error", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	if revisions == nil {
+		revisions = []ctrl.MessageRevision{}
+	}
+
+	response, _ := json.Marshal(revisions)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(response)
+}
+
+// thread lists the conversation a message belongs to, oldest first. Clients
+// can build the reply tree from the in_reply_to of each message.
+func (a *API) thread(w http.ResponseWriter, r *http.Request) {
+	a.updateLatest(r)
+
+	if _, errResponse := a.authorize(r, ctrl.ScopeRead); errResponse != nil {
+		writeResponse(w, errResponse)
+		return
+	}
+
+	msg, ok := a.lookupMessage(w, r)
+
+	if !ok {
+		return
+	}
+
+	messages, err := a.storesFor(r).Messages.GetThread(msg.ID)
+
+	if err != nil {
+		logging.FromRequest(r).Error("Error in database lookup", "func", "thread", "error", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	if messages == nil {
+		messages = []ctrl.Message{}
+	}
+
+	response, _ := json.Marshal(messages)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(response)
+}
+
+func (a *API) follow(w http.ResponseWriter, r *http.Request) {
+	a.updateLatest(r)
+	scope := ctrl.ScopeRead
+
+	if r.Method == "POST" {
+		scope = ctrl.ScopeFollow
+	}
+
+	caller, errResponse := a.authorizeSimulator(r, scope)
+
+	if errResponse != nil {
+		writeResponse(w, errResponse)
 		return
 	}
 
 	var status int
-	userID := ctrl.GetUserID(mux.Vars(r)["username"], db)
+	userID := a.storesFor(r).Users.GetUserID(mux.Vars(r)["username"])
 
 	if userID == 0 {
 		w.WriteHeader(404)
@@ -317,53 +869,47 @@ func follow(w http.ResponseWriter, r *http.Request) {
 
 	json.NewDecoder(r.Body).Decode(&reqData)
 
+	if r.Method == "POST" && !caller.actsAs(userID) {
+		writeResponse(w, forbidden("You can only follow and unfollow as yourself"))
+		return
+	}
+
 	if len(reqData.Follow) != 0 && r.Method == "POST" {
 		status = 204
-		followID := ctrl.GetUserID(reqData.Follow, db)
+		followID := a.storesFor(r).Users.GetUserID(reqData.Follow)
 
 		if followID == 0 {
 			status = 404
+		} else if err := a.storesFor(r).Follows.Follow(userID, followID); err != nil {
+			logging.FromRequest(r).Error("Error in database lookup", "func", "follow", "error", err)
+			status = 500
 		} else {
-			query := db.FirstOrCreate(&ctrl.Follower{}, &ctrl.Follower{
-				FollowerID: userID,
-				FollowsID:  followID,
-			})
-
-			if query.Error != nil && !errors.Is(query.Error, gorm.ErrRecordNotFound) {
-				fmt.Fprintf(os.Stderr, "follow: Error in database lookup: %s\n", query.Error)
-				status = 500
-			}
+			mntr.Followed(mntr.SourceAPI)
 		}
 	} else if len(reqData.Unfollow) != 0 && r.Method == "POST" {
 		status = 204
-		unfollowID := ctrl.GetUserID(reqData.Unfollow, db)
+		unfollowID := a.storesFor(r).Users.GetUserID(reqData.Unfollow)
 
 		if unfollowID == 0 {
 			w.WriteHeader(404)
 			return
 		}
 
-		query := db.Delete(&ctrl.Follower{
-			FollowerID: userID,
-			FollowsID:  unfollowID,
-		})
-
-		if query.Error != nil && !errors.Is(query.Error, gorm.ErrRecordNotFound) {
-			fmt.Fprintf(os.Stderr, "follow: Error in database lookup: %s\n", query.Error)
+		if err := a.storesFor(r).Follows.Unfollow(userID, unfollowID); err != nil {
+			logging.FromRequest(r).Error("Error in database lookup", "func", "follow", "error", err)
 			status = 500
+		} else {
+			mntr.Unfollowed(mntr.SourceAPI)
 		}
 	} else if r.Method == "GET" {
 		w.Header().Set("Content-Type", "application/json")
 		status = 200
 
-		var followers []ctrl.User
 		var followerNames []interface{}
+		followers, err := a.storesFor(r).Follows.GetFollowers(userID)
 
-		query := db.Select("users.username").Joins("INNER JOIN followers ON users.id = followers.follower_id").
-			Find(&followers, "followers.follows_id = ?", userID)
-
-		if query.Error != nil && !errors.Is(query.Error, gorm.ErrRecordNotFound) {
-			fmt.Fprintf(os.Stderr, "follow: Error in database lookup: %s\n", query.Error)
+		if err != nil {
+			logging.FromRequest(r).Error("Error in database lookup", "func", "follow", "error", err)
 			status = 500
 		} else {
 			for _, f := range followers {
@@ -376,6 +922,9 @@ func follow(w http.ResponseWriter, r *http.Request) {
 
 			w.Write(response)
 		}
+	} else {
+		writeBadRequest(w, errors.New("either follow or unfollow is required"))
+		return
 	}
 
 	w.WriteHeader(status)
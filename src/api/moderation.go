@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	ctrl "minitwit/controllers"
+	"minitwit/logging"
+)
+
+// authorizeModerator authorizes a request to one of the moderation endpoints,
+// which need a token with the moderate scope held by a moderator.
+func (a *API) authorizeModerator(w http.ResponseWriter, r *http.Request) (*caller, bool) {
+	caller, errResponse := a.authorize(r, ctrl.ScopeModerate)
+
+	if errResponse == nil && !caller.user.Moderator {
+		errResponse = forbidden("Only moderators can use this resource")
+	}
+
+	if errResponse != nil {
+		writeResponse(w, errResponse)
+		return nil, false
+	}
+
+	return caller, true
+}
+
+func writeReportedMessages(w http.ResponseWriter, reported []ctrl.ReportedMessage) {
+	if reported == nil {
+		reported = []ctrl.ReportedMessage{}
+	}
+
+	response, _ := json.Marshal(reported)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(response)
+}
+
+func (a *API) reportMessage(w http.ResponseWriter, r *http.Request) {
+	a.updateLatest(r)
+	caller, errResponse := a.authorize(r, ctrl.ScopePost)
+
+	if errResponse != nil {
+		writeResponse(w, errResponse)
+		return
+	}
+
+	msg, ok := a.lookupMessage(w, r)
+
+	if !ok {
+		return
+	}
+
+	reqData := struct {
+		Reason string `json:"reason"`
+	}{}
+
+	json.NewDecoder(r.Body).Decode(&reqData)
+
+	if reqData.Reason == "" {
+		writeBadRequest(w, errors.New("reason cannot be empty"))
+		return
+	}
+
+	err := a.storesFor(r).Moderation.ReportMessage(&ctrl.Report{
+		MessageID:  msg.ID,
+		ReporterID: caller.user.ID,
+		Reason:     reqData.Reason,
+		Date:       time.Now().Unix(),
+	})
+
+	if err != nil {
+		logging.FromRequest(r).Error("Error in creating database record", "func", "reportMessage", "error", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	w.WriteHeader(204)
+}
+
+func (a *API) openReports(w http.ResponseWriter, r *http.Request) {
+	if _, ok := a.authorizeModerator(w, r); !ok {
+		return
+	}
+
+	reported, err := a.storesFor(r).Moderation.GetOpenReports()
+
+	if err != nil {
+		logging.FromRequest(r).Error("Error in database lookup", "func", "openReports", "error", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	writeReportedMessages(w, reported)
+}
+
+func (a *API) flaggedMessages(w http.ResponseWriter, r *http.Request) {
+	if _, ok := a.authorizeModerator(w, r); !ok {
+		return
+	}
+
+	reported, err := a.storesFor(r).Moderation.GetFlaggedMessages()
+
+	if err != nil {
+		logging.FromRequest(r).Error("Error in database lookup", "func", "flaggedMessages", "error", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	writeReportedMessages(w, reported)
+}
+
+// moderateMessage flags, unflags or dismisses the reports of a message,
+// depending on the action in the URL.
+func (a *API) moderateMessage(w http.ResponseWriter, r *http.Request) {
+	caller, ok := a.authorizeModerator(w, r)
+
+	if !ok {
+		return
+	}
+
+	vars := mux.Vars(r)
+	id, _ := strconv.ParseUint(vars["id"], 10, 0)
+	var err error
+
+	switch vars["action"] {
+	case "flag":
+		reqData := struct {
+			Reason string `json:"reason"`
+		}{}
+
+		json.NewDecoder(r.Body).Decode(&reqData)
+
+		if reqData.Reason == "" {
+			writeBadRequest(w, errors.New("reason cannot be empty"))
+			return
+		}
+
+		err = a.storesFor(r).Moderation.FlagMessage(uint(id), caller.user.ID, reqData.Reason, time.Now().Unix())
+	case "unflag":
+		err = a.storesFor(r).Moderation.UnflagMessage(uint(id))
+	case "dismiss":
+		err = a.storesFor(r).Moderation.DismissReports(uint(id))
+	}
+
+	if errors.Is(err, ctrl.ErrNotFound) {
+		w.WriteHeader(404)
+		return
+	} else if err != nil {
+		logging.FromRequest(r).Error("Error in updating database record", "func", "moderateMessage", "error", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	w.WriteHeader(204)
+}
+
+func (a *API) suspendUser(w http.ResponseWriter, r *http.Request) {
+	if _, ok := a.authorizeModerator(w, r); !ok {
+		return
+	}
+
+	vars := mux.Vars(r)
+	userID := a.storesFor(r).Users.GetUserID(vars["username"])
+
+	if userID == 0 {
+		w.WriteHeader(404)
+		return
+	}
+
+	if err := a.storesFor(r).Moderation.SetSuspended(userID, vars["action"] == "suspend"); err != nil {
+		logging.FromRequest(r).Error("Error in updating database record", "func", "suspendUser", "error", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	w.WriteHeader(204)
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+
+	"minitwit/config"
+	ctrl "minitwit/controllers"
+	"minitwit/migrations"
+)
+
+// testClient talks to an App served from the in-memory stores and keeps the
+// session cookie between requests.
+type testClient struct {
+	t      *testing.T
+	server *httptest.Server
+	client *http.Client
+}
+
+func newTestClient(t *testing.T, stores ctrl.Stores) *testClient {
+	t.Helper()
+
+	templates, err := NewTemplates(false)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(NewApp(stores, templates, []byte("test-session-key")).Router())
+	t.Cleanup(server.Close)
+
+	jar, _ := cookiejar.New(nil)
+	return &testClient{t: t, server: server, client: &http.Client{Jar: jar}}
+}
+
+// get returns the status and body of the page at path.
+func (c *testClient) get(path string) (int, string) {
+	c.t.Helper()
+
+	resp, err := c.client.Get(c.server.URL + path)
+
+	if err != nil {
+		c.t.Fatal(err)
+	}
+
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(body)
+}
+
+// post submits a form to path and returns the status and body of the page it
+// redirects to.
+func (c *testClient) post(path string, form url.Values) (int, string) {
+	c.t.Helper()
+
+	resp, err := c.client.PostForm(c.server.URL+path, form)
+
+	if err != nil {
+		c.t.Fatal(err)
+	}
+
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(body)
+}
+
+func (c *testClient) signUp(username string) {
+	c.t.Helper()
+
+	c.post("/register", url.Values{
+		"username":  {username},
+		"email":     {username + "@example.com"},
+		"password":  {"secret"},
+		"password2": {"secret"},
+	})
+
+	if status, body := c.post("/login", url.Values{"username": {username}, "password": {"secret"}}); status != 200 || !strings.Contains(body, "log out") {
+		c.t.Fatalf("signing in as %s: status %d", username, status)
+	}
+}
+
+func TestPostedMessageShowsInTimelines(t *testing.T) {
+	c := newTestClient(t, ctrl.NewMemoryStores())
+	c.signUp("alice")
+
+	if status, _ := c.post("/add_message", url.Values{"text": {"Hello #world"}}); status != 200 {
+		t.Fatalf("posting: status %d", status)
+	}
+
+	for _, path := range []string{"/", "/public", "/alice", "/tag/world"} {
+		status, body := c.get(path)
+
+		if status != 200 || !strings.Contains(body, "Hello") {
+			t.Errorf("%s: status %d, message missing", path, status)
+		}
+	}
+}
+
+func TestUnknownUserIsNotFound(t *testing.T) {
+	c := newTestClient(t, ctrl.NewMemoryStores())
+
+	if status, _ := c.get("/nobody"); status != 404 {
+		t.Errorf("status %d, want 404", status)
+	}
+}
+
+func TestTimelineRedirectsAnonymousUsers(t *testing.T) {
+	c := newTestClient(t, ctrl.NewMemoryStores())
+
+	if status, body := c.get("/"); status != 200 || !strings.Contains(body, "Public Timeline") {
+		t.Errorf("status %d, want the public timeline", status)
+	}
+}
+
+func TestLikeAndUnlike(t *testing.T) {
+	stores := ctrl.NewMemoryStores()
+	c := newTestClient(t, stores)
+	c.signUp("alice")
+	c.post("/add_message", url.Values{"text": {"Like me"}})
+
+	// Liking twice counts once
+	c.post("/message/1/like", url.Values{"next": {"/public"}})
+	c.post("/message/1/like", url.Values{"next": {"/public"}})
+
+	if msg, _ := stores.Messages.GetMessage(1); msg.LikeCount != 1 {
+		t.Errorf("like count %d after liking twice, want 1", msg.LikeCount)
+	}
+
+	if _, body := c.get("/alice/likes"); !strings.Contains(body, "Like me") {
+		t.Error("liked message missing from the likes tab")
+	}
+
+	c.post("/message/1/unlike", url.Values{"next": {"/public"}})
+
+	if msg, _ := stores.Messages.GetMessage(1); msg.LikeCount != 0 {
+		t.Errorf("like count %d after unliking, want 0", msg.LikeCount)
+	}
+}
+
+func TestRevokedSessionIsNotSavedAgain(t *testing.T) {
+	stores := ctrl.NewMemoryStores()
+	store := ctrl.NewServerSessionStore(stores.Sessions, []byte("test-session-key"))
+
+	rec := httptest.NewRecorder()
+	session, _ := store.New(httptest.NewRequest("GET", "/", nil), "user-session")
+	session.Values["user_id"] = uint(1)
+
+	if err := store.Save(httptest.NewRequest("GET", "/", nil), rec, session); err != nil {
+		t.Fatal(err)
+	}
+
+	// Another device revokes the session while this request still holds it
+	if err := stores.Sessions.DeleteUserSessions(1, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	rec = httptest.NewRecorder()
+	session.AddFlash("Too late")
+
+	if err := store.Save(httptest.NewRequest("GET", "/", nil), rec, session); err != nil {
+		t.Fatal(err)
+	}
+
+	if list, _ := stores.Sessions.GetUserSessions(1, 0); len(list) != 0 {
+		t.Errorf("%d sessions after revoking, want 0", len(list))
+	}
+
+	if cookies := rec.Result().Cookies(); len(cookies) != 1 || cookies[0].MaxAge >= 0 {
+		t.Errorf("got cookies %v, want the session cookie expired", cookies)
+	}
+}
+
+func TestExtendedSessionRenewsCookie(t *testing.T) {
+	stores := ctrl.NewMemoryStores()
+	c := newTestClient(t, stores)
+	c.signUp("alice")
+
+	list, _ := stores.Sessions.GetUserSessions(1, 0)
+
+	if len(list) != 1 {
+		t.Fatalf("%d sessions, want 1", len(list))
+	}
+
+	// Pretend the session was last seen a day ago
+	stores.Sessions.TouchSession(list[0].Hash, list[0].LastSeen-86400, list[0].ExpiresAt-86400)
+
+	resp, err := c.client.Get(c.server.URL + "/")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp.Body.Close()
+
+	if cookies := resp.Cookies(); len(cookies) != 1 || cookies[0].MaxAge <= 0 {
+		t.Errorf("got cookies %v, want the session cookie set again", cookies)
+	}
+}
+
+// TestTimelineQueryCount renders the timelines with one message and with more
+// messages than fit on a page, and checks that the number of database queries
+// does not grow with the messages shown.
+func TestTimelineQueryCount(t *testing.T) {
+	db, err := ctrl.ConnectDB(config.DBConfig{Driver: "sqlite", Path: "file::memory:", MaxOpenConns: 1, MaxIdleConns: 1})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := migrations.Up(db); err != nil {
+		t.Fatal(err)
+	}
+
+	var queries int64
+	count := func(*gorm.DB) { atomic.AddInt64(&queries, 1) }
+	db.Callback().Query().After("gorm:query").Register("test:count_query", count)
+	db.Callback().Row().After("gorm:row").Register("test:count_row", count)
+	db.Callback().Raw().After("gorm:raw").Register("test:count_raw", count)
+
+	stores := ctrl.NewGormStores(db)
+	newTestClient(t, stores).signUp("alice")
+
+	authors := []ctrl.User{{Username: "bob"}, {Username: "carol"}, {Username: "dave"}}
+
+	for i := range authors {
+		authors[i].Email = authors[i].Username + "@example.com"
+
+		if err := stores.Users.CreateUser(&authors[i]); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := stores.Follows.Follow(1, authors[i].ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var first uint
+
+	post := func(n int) {
+		for i := 0; i < n; i++ {
+			author := authors[i%len(authors)]
+			msg := ctrl.Message{
+				AuthorID:  author.ID,
+				Text:      fmt.Sprintf("Message %d for @alice and @%s #go", i, authors[(i+1)%len(authors)].Username),
+				Date:      time.Now().Unix(),
+				InReplyTo: first,
+			}
+
+			if err := stores.Messages.CreateMessage(&msg); err != nil {
+				t.Fatal(err)
+			}
+
+			if first == 0 {
+				first = msg.ID
+			}
+
+			for _, liker := range authors {
+				if err := stores.Likes.Like(liker.ID, msg.ID, msg.Date); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := stores.Likes.Like(1, msg.ID, msg.Date); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	paths := []string{"/", "/public", "/bob", "/tag/go", "/alice/mentions", "/alice/likes"}
+
+	// render counts the queries of each path, each time on a fresh App so that
+	// no cache carries over between the runs.
+	render := func() map[string]int64 {
+		counts := make(map[string]int64)
+
+		for _, path := range paths {
+			c := newTestClient(t, stores)
+			c.signUp("alice")
+			atomic.StoreInt64(&queries, 0)
+
+			if status, body := c.get(path); status != 200 || !strings.Contains(body, "#go") {
+				t.Fatalf("%s: status %d, message missing", path, status)
+			}
+
+			counts[path] = atomic.LoadInt64(&queries)
+		}
+
+		return counts
+	}
+
+	post(1)
+	few := render()
+	post(2*perPage + 5)
+	many := render()
+
+	for _, path := range paths {
+		if few[path] != many[path] {
+			t.Errorf("%s: %d queries with one message, %d with a full page", path, few[path], many[path])
+		}
+	}
+}
+
+func TestSearchPageIsBounded(t *testing.T) {
+	c := newTestClient(t, ctrl.NewMemoryStores())
+	c.signUp("alice")
+	c.post("/add_message", url.Values{"text": {"Hello search"}})
+
+	if status, _ := c.get("/search?q=hello&page=9223372036854775807"); status != 200 {
+		t.Errorf("status %d for a huge page, want 200", status)
+	}
+
+	if status, body := c.get("/search?q=hello"); status != 200 || !strings.Contains(body, "Hello search") {
+		t.Errorf("status %d, want the message found", status)
+	}
+}
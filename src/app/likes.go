@@ -0,0 +1,133 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	ctrl "minitwit/controllers"
+	"minitwit/logging"
+)
+
+// likedMessages reports which of the messages userID likes, for the state of
+// the like buttons. On errors all buttons offer to like rather than failing
+// the page.
+func (a *App) likedMessages(r *http.Request, userID uint, messages []ctrl.Message) map[uint]bool {
+	if userID == 0 || len(messages) == 0 {
+		return nil
+	}
+
+	ids := make([]uint, len(messages))
+
+	for i, m := range messages {
+		ids[i] = m.ID
+	}
+
+	liked, err := a.storesFor(r).Likes.GetLikedIDs(userID, ids)
+
+	if err != nil {
+		logging.FromRequest(r).Error("Error in database lookup", "func", "likedMessages", "error", err)
+		return nil
+	}
+
+	return liked
+}
+
+// localPath returns next if it is a path on this site, and "/" otherwise, so
+// that the next parameter cannot redirect to other sites.
+func localPath(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.Contains(next, `\`) {
+		return "/"
+	}
+
+	return next
+}
+
+// likeMessage likes or unlikes a message and returns to the page given by the
+// next form value.
+func (a *App) likeMessage(w http.ResponseWriter, r *http.Request) {
+	_, user := a.getUserSession(w, r)
+
+	if user.ID == 0 {
+		w.WriteHeader(401)
+		return
+	}
+
+	vars := mux.Vars(r)
+	id, _ := strconv.ParseUint(vars["id"], 10, 0)
+	msg, err := a.storesFor(r).Messages.GetMessage(uint(id))
+
+	if errors.Is(err, ctrl.ErrNotFound) || (err == nil && msg.Flagged != ctrl.FlagNone) {
+		w.WriteHeader(404)
+		return
+	} else if err != nil {
+		logging.FromRequest(r).Error("Error in database lookup", "func", "likeMessage", "error", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	if vars["action"] == "like" {
+		err = a.storesFor(r).Likes.Like(user.ID, msg.ID, time.Now().Unix())
+	} else {
+		err = a.storesFor(r).Likes.Unlike(user.ID, msg.ID)
+	}
+
+	if err != nil {
+		logging.FromRequest(r).Error("Error in updating database record", "func", "likeMessage", "error", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	http.Redirect(w, r, localPath(r.FormValue("next")), http.StatusSeeOther)
+}
+
+func (a *App) likesTimeline(w http.ResponseWriter, r *http.Request) {
+	profileUser, err := a.storesFor(r).Users.GetUserByUsername(mux.Vars(r)["username"])
+
+	if err != nil {
+		if errors.Is(err, ctrl.ErrNotFound) {
+			w.WriteHeader(404)
+			return
+		}
+
+		logging.FromRequest(r).Error("Error in database lookup", "func", "likesTimeline", "error", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	page, err := timelinePage(r)
+
+	if err != nil {
+		w.WriteHeader(400)
+		return
+	}
+
+	messages, err := a.storesFor(r).Messages.GetLikedMessages(profileUser.ID, page)
+
+	if err != nil {
+		logging.FromRequest(r).Error("Error getting messages", "func", "likesTimeline", "error", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	messages, older, newer := paginate(r, page, messages)
+	_, user := a.getUserSession(w, r)
+
+	data := TimelineData{
+		RequestUrl:   r.URL.Path,
+		Messages:     messages,
+		OlderUrl:     older,
+		NewerUrl:     newer,
+		Likes:        true,
+		Profile_User: ctrl.User{Username: profileUser.Username},
+		Liked:        a.likedMessages(r, user.ID, messages),
+		Trending:     a.trendingTags(r),
+		SessionData:  SessionData{User: user},
+	}
+
+	a.templates.Render(w, r, "timeline.html", data)
+}
@@ -1,7 +1,9 @@
 package main
 
 import (
+	"context"
 	"errors"
+	"flag"
 	"fmt"
 	"strconv"
 
@@ -10,19 +12,21 @@ import (
 	"html/template"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/gorilla/sessions"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
-
-	"golang.org/x/crypto/bcrypt"
-	"gorm.io/gorm"
 
+	"minitwit/config"
 	ctrl "minitwit/controllers"
+	"minitwit/logging"
+	"minitwit/migrations"
 	mntr "minitwit/monitoring"
+	"minitwit/server"
 )
 
 type SessionData struct {
@@ -35,71 +39,181 @@ type TimelineData struct {
 	Followed     bool
 	Profile_User ctrl.User
 	Messages     []ctrl.Message
+	OlderUrl     string
+	NewerUrl     string
+	Mentions     bool          // the page lists the messages mentioning Profile_User
+	Likes        bool          // the page lists the messages Profile_User likes
+	Tag          string        // the page lists the messages with this tag
+	Liked        map[uint]bool // the messages the logged in user likes
+	Trending     []ctrl.TagCount
 	SessionData  SessionData
 }
 
-var (
-	db    *gorm.DB
-	store = sessions.NewCookieStore([]byte(os.Getenv("SESSION_KEY")))
-)
+// App holds what the web handlers depend on. main serves it from the
+// database, while tests can serve it from the in-memory stores.
+type App struct {
+	stores    ctrl.Stores
+	sessions  *ctrl.ServerSessionStore
+	templates *Templates
+	trending  trendingCache
+}
+
+func NewApp(stores ctrl.Stores, templates *Templates, sessionKey []byte) *App {
+	return &App{
+		stores:    stores,
+		sessions:  ctrl.NewServerSessionStore(stores.Sessions, sessionKey),
+		templates: templates,
+	}
+}
 
 const (
 	perPage = 30
-	port    = 8080
 )
 
 func main() {
-	db = ctrl.ConnectDB()
-	r := mux.NewRouter()
+	dev := flag.Bool("dev", false, "read templates from ./static and reload them when they change")
+	flag.Parse()
 
-	// Endpoints
-	r.HandleFunc("/", timeline)
-	r.HandleFunc("/favicon.ico", func(w http.ResponseWriter, r *http.Request) {})
-	r.HandleFunc("/public", publicTimeline)
-	r.HandleFunc("/add_message", addMessage).Methods("POST")
-	r.HandleFunc("/login", login).Methods("GET", "POST")
-	r.HandleFunc("/register", register).Methods("GET", "POST")
-	r.HandleFunc("/logout", logout)
-	r.HandleFunc("/{username}", userTimeline)
-	r.HandleFunc("/{username}/follow", follow)
-	r.HandleFunc("/{username}/unfollow", unfollow)
+	logging.SetDefault(logging.New(os.Stderr).With("service", "app"))
 
-	// Load CSS
-	r.PathPrefix("/static/css/").Handler(http.StripPrefix("/static/css/", http.FileServer(http.Dir("./static/css/"))))
+	cfg, err := config.Load()
+
+	if err != nil {
+		logging.Error("Error loading configuration", "error", err)
+		os.Exit(1)
+	}
 
-	/*
-	   Prometheus metrics setup
-	*/
+	shutdownTracing, err := mntr.SetupTracing("minitwit-app", cfg.Tracing)
+
+	if err != nil {
+		logging.Error("Error setting up tracing", "error", err)
+		os.Exit(1)
+	}
 
-	http.Handle("/metrics", promhttp.Handler())
+	db, err := ctrl.ConnectDB(cfg.DB)
 
-	// Use goroutine because http.ListenAndServe() is a blocking method
-	go func() {
-		if err := http.ListenAndServe(":2112", nil); err != nil {
-			fmt.Fprintf(os.Stderr, "Error serving for Prometheus: %s\n", err)
+	if err != nil {
+		logging.Error("Error connecting to database", "error", err)
+		os.Exit(1)
+	}
+
+	if err := mntr.InstrumentDB(db, "minitwit"); err != nil {
+		logging.Error("Error instrumenting database", "error", err)
+		os.Exit(1)
+	}
+
+	if cfg.DB.AutoMigrate {
+		if _, err := migrations.Up(db); err != nil {
+			logging.Error("Error applying migrations", "error", err)
 			os.Exit(1)
 		}
-	}()
+	}
 
-	/*
-	   Start app server
-	*/
+	if err := ctrl.SyncSearchIndex(db); err != nil {
+		logging.Error("Error updating the search index", "error", err)
+		os.Exit(1)
+	}
 
-	// Register r as HTTP handler
-	http.Handle("/", mntr.MiddlewareMetrics(r, false))
+	templates, err := NewTemplates(*dev)
 
-	srv := &http.Server{
-		Addr:         "0.0.0.0:" + strconv.Itoa(port),
-		WriteTimeout: 10 * time.Second,
-		ReadTimeout:  10 * time.Second,
+	if err != nil {
+		logging.Error("Error loading templates", "error", err)
+		os.Exit(1)
 	}
 
-	fmt.Printf("MiniTwit App listening on port %v\n", port)
+	app := NewApp(ctrl.NewGormStores(db), templates, []byte(os.Getenv("SESSION_KEY")))
+
+	go mntr.CollectProcessMetrics(15 * time.Second)
+	go mntr.TrackTotals(time.Minute, func(activeSince int64) (mntr.Totals, error) {
+		totals, err := app.stores.Stats.GetTotals(activeSince)
+		return mntr.Totals(totals), err
+	})
+	go app.purgeSessions()
+
+	r := app.Router()
+
+	handler := http.NewServeMux()
+	handler.Handle("/", mntr.MiddlewareTracing(r, mntr.MiddlewareAccessLog(r, mntr.MiddlewareMetrics(r, false))))
+
+	// Health checks are served next to the router, so that probes do not
+	// show up in the access log and request metrics.
+	handler.HandleFunc("/healthz", mntr.Healthz)
+	handler.Handle("/readyz", mntr.Readyz(
+		mntr.DatabaseCheck(db),
+		mntr.MigrationsCheck(db),
+		mntr.Check{Name: "templates", Run: templates.Check},
+	))
+
+	logging.Info("MiniTwit App listening", "port", cfg.Server.AppPort, "metrics_port", cfg.Server.MetricsPort)
 
-	if err := srv.ListenAndServe(); err != nil {
-		fmt.Fprintf(os.Stderr, "Error serving on port %v: %s\n", port, err)
+	err = server.Run(time.Duration(cfg.Server.ShutdownTimeout),
+		server.New(cfg.Server.AppPort, handler, cfg.Server),
+		server.New(cfg.Server.MetricsPort, mntr.MetricsHandler(), cfg.Server),
+	)
+
+	// The servers are drained, so nothing uses the tracer or the database
+	// anymore.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.Server.ShutdownTimeout))
+
+	if err := shutdownTracing(ctx); err != nil {
+		logging.Error("Error flushing traces", "error", err)
+	}
+
+	cancel()
+
+	if sqlDB, err := db.DB(); err == nil {
+		sqlDB.Close()
+	}
+
+	if err != nil {
+		logging.Error("Error serving", "error", err)
 		os.Exit(1)
 	}
+
+	logging.Info("MiniTwit App stopped")
+}
+
+// Router returns the routes of the web app.
+func (a *App) Router() *mux.Router {
+	r := mux.NewRouter()
+
+	// Endpoints
+	r.HandleFunc("/", a.timeline)
+	r.HandleFunc("/favicon.ico", func(w http.ResponseWriter, r *http.Request) {})
+	r.HandleFunc("/public", a.publicTimeline)
+	r.HandleFunc("/add_message", a.addMessage).Methods("POST")
+	r.HandleFunc("/message/{id:[0-9]+}/edit", a.editMessage).Methods("GET", "POST")
+	r.HandleFunc("/message/{id:[0-9]+}/delete", a.deleteMessage).Methods("POST")
+	r.HandleFunc("/message/{id:[0-9]+}/report", a.reportMessage).Methods("GET", "POST")
+	r.HandleFunc("/message/{id:[0-9]+}/{action:like|unlike}", a.likeMessage).Methods("POST")
+	r.HandleFunc("/moderation", a.moderationQueue)
+	r.HandleFunc("/moderation/message/{id:[0-9]+}/{action:flag|unflag|dismiss}", a.moderateMessage).Methods("POST")
+	r.HandleFunc("/moderation/user/{username}/{action:suspend|unsuspend}", a.suspendUser).Methods("POST")
+	r.HandleFunc("/login", a.login).Methods("GET", "POST")
+	r.HandleFunc("/register", a.register).Methods("GET", "POST")
+	r.HandleFunc("/logout", a.logout)
+	r.HandleFunc("/sessions", a.activeSessions)
+	r.HandleFunc("/sessions/{id:[0-9]+}/revoke", a.revokeSession).Methods("POST")
+	r.HandleFunc("/sessions/revoke-others", a.revokeOtherSessions).Methods("POST")
+	r.HandleFunc("/tag/{name}", a.tagTimeline)
+	r.HandleFunc("/search", a.search)
+	r.HandleFunc("/{username}", a.userTimeline)
+	r.HandleFunc("/{username}/follow", a.follow)
+	r.HandleFunc("/{username}/unfollow", a.unfollow)
+	r.HandleFunc("/{username}/mentions", a.mentionsTimeline)
+	r.HandleFunc("/{username}/likes", a.likesTimeline)
+	r.HandleFunc("/{username}/status/{id:[0-9]+}", a.thread)
+
+	// Load CSS
+	r.PathPrefix("/static/css/").Handler(http.StripPrefix("/static/", http.FileServer(http.FS(a.templates.Static()))))
+
+	return r
+}
+
+// storesFor returns the stores bound to the context of r, so that their
+// queries are traced as part of the request.
+func (a *App) storesFor(r *http.Request) ctrl.Stores {
+	return a.stores.WithContext(r.Context())
 }
 
 // Default size: 80
@@ -110,8 +224,59 @@ func gravatarUrl(email string, size int) string {
 	return fmt.Sprintf("https://www.gravatar.com/avatar/%s?d=identicon&s=%d", hex.EncodeToString(hash.Sum(nil)), size)
 }
 
-func getUserSession(w http.ResponseWriter, r *http.Request) (*sessions.Session, ctrl.User) {
-	session, _ := store.Get(r, "user-session")
+func formatDatetime(t int64) string {
+	return time.Unix(t, 0).Format("2006-01-02 @ 15:04")
+}
+
+// textLink is a part of a message's text that is shown as a link.
+type textLink struct {
+	start, end int
+	class      string
+	href       string
+}
+
+// formatMessage escapes the text of msg and links the users it mentions to
+// their timelines and its tags to the tag pages. An @name that is not a known
+// user stays plain text.
+func formatMessage(msg ctrl.Message) template.HTML {
+	mentioned := make(map[string]bool, len(msg.Mentions))
+
+	for _, name := range msg.Mentions {
+		mentioned[name] = true
+	}
+
+	var links []textLink
+
+	// In each match, [2:4] is the name; the @ or # comes right before it
+	for _, match := range ctrl.MentionPattern.FindAllStringSubmatchIndex(msg.Text, -1) {
+		if name := msg.Text[match[2]:match[3]]; mentioned[name] {
+			links = append(links, textLink{match[2] - 1, match[3], "mention", "/" + url.PathEscape(name)})
+		}
+	}
+
+	for _, match := range ctrl.TagPattern.FindAllStringSubmatchIndex(msg.Text, -1) {
+		tag := strings.ToLower(msg.Text[match[2]:match[3]])
+		links = append(links, textLink{match[2] - 1, match[3], "tag", "/tag/" + tag})
+	}
+
+	sort.Slice(links, func(i, j int) bool { return links[i].start < links[j].start })
+
+	var b strings.Builder
+	last := 0
+
+	for _, link := range links {
+		b.WriteString(template.HTMLEscapeString(msg.Text[last:link.start]))
+		fmt.Fprintf(&b, `<a class=%s href="%s">%s</a>`, link.class,
+			template.HTMLEscapeString(link.href), template.HTMLEscapeString(msg.Text[link.start:link.end]))
+		last = link.end
+	}
+
+	b.WriteString(template.HTMLEscapeString(msg.Text[last:]))
+	return template.HTML(b.String()) // #nosec G203
+}
+
+func (a *App) getUserSession(w http.ResponseWriter, r *http.Request) (*sessions.Session, ctrl.User) {
+	session, _ := a.sessions.Get(r, "user-session")
 
 	var user ctrl.User
 
@@ -121,202 +286,302 @@ func getUserSession(w http.ResponseWriter, r *http.Request) (*sessions.Session,
 			Username: "",
 		}
 
-		clearUserSessionData(w, r)
+		a.clearUserSessionData(w, r)
 	} else {
+		moderator, _ := session.Values["moderator"].(bool)
 		user = ctrl.User{
-			ID:       session.Values["user_id"].(uint),
-			Username: session.Values["username"].(string),
+			ID:        session.Values["user_id"].(uint),
+			Username:  session.Values["username"].(string),
+			Moderator: moderator,
+		}
+
+		logging.SetUser(r, user.Username)
+
+		if err := a.sessions.Refresh(w, session); err != nil {
+			logging.FromRequest(r).Error("Error in encoding session cookie", "func", "getUserSession", "error", err)
 		}
 	}
 
 	return session, user
 }
 
-func getMessages(w http.ResponseWriter, r *http.Request, public bool, own bool) ([]ctrl.Message, error) {
-	_, user := getUserSession(w, r)
-	var messages []ctrl.Message
+// timelinePage reads the before and after cursors of a timeline request. It
+// asks for one message more than is shown, to tell whether there is another page.
+func timelinePage(r *http.Request) (ctrl.Page, error) {
+	page := ctrl.Page{Limit: perPage + 1}
+	params := r.URL.Query()
 
-	if public {
-		query := db.Limit(perPage).
-			Joins("JOIN users ON messages.author_id = users.id").
-			Order("messages.date desc").
-			Find(&messages, "flagged = ?", 0)
+	if before := params.Get("before"); before != "" {
+		cursor, err := ctrl.ParseCursor(before)
 
-		if query.Error != nil && !errors.Is(query.Error, gorm.ErrRecordNotFound) {
-			return nil, query.Error
+		if err != nil {
+			return page, err
 		}
-	} else if own {
-		subquery := db.Select("follows_id").Find(&ctrl.Follower{}, "follower_id = ?", user.ID)
-		query := db.Limit(perPage).
-			Joins("JOIN users ON messages.author_id = users.id").
-			Order("messages.date desc").
-			Where("users.id = ?", user.ID).
-			Or("users.id IN (?)", subquery).
-			Find(&messages, "flagged = ?", 0)
-
-		if subquery.Error != nil && !errors.Is(subquery.Error, gorm.ErrRecordNotFound) {
-			return nil, subquery.Error
-		} else if query.Error != nil && !errors.Is(query.Error, gorm.ErrRecordNotFound) {
-			return nil, query.Error
-		}
-	} else {
-		username := mux.Vars(r)["username"]
 
-		query := db.Limit(perPage).
-			Order("date desc").
-			Joins("JOIN users ON messages.author_id = users.id").
-			Find(&messages, "messages.flagged = ? AND users.username = ?", 0, username)
+		page.Before = &cursor
+	} else if after := params.Get("after"); after != "" {
+		cursor, err := ctrl.ParseCursor(after)
 
-		if query.Error != nil && !errors.Is(query.Error, gorm.ErrRecordNotFound) {
-			return nil, query.Error
+		if err != nil {
+			return page, err
 		}
+
+		page.After = &cursor
 	}
 
-	return messages, nil
+	return page, nil
 }
 
-func setupTimelineTemplates(data TimelineData) *template.Template {
-	tmpl, err := template.New("timeline.html").Funcs(template.FuncMap{
-		"gravatar_url": func(authorID uint, size int) string {
-			var author ctrl.User
-			db.First(&author, "id = ?", authorID)
-			return gravatarUrl(author.Email, size)
-		},
-		"format_datetime": func(t int64) string {
-			return time.Unix(t, 0).Format("2006-01-02 @ 15:04")
-		},
-		"timeline_title": func() string {
-			if data.RequestUrl == "/public" {
-				return "Public Timeline"
-			} else if data.RequestUrl[0] == '/' && len(data.RequestUrl) > 1 {
-				return data.Profile_User.Username + "'s Timeline"
-			} else {
-				return "My Timeline"
-			}
-		},
-		"requestUserTimeline": func() bool {
-			return data.RequestUrl[0] == '/' && len(data.RequestUrl) > 1 && data.RequestUrl != "/public"
-		},
-		"get_username": func(id uint) string {
-			var user ctrl.User
-			db.First(&user, "id = ?", id)
-			return user.Username
-		},
-	}).ParseFiles("static/timeline.html", "static/layout.html")
+// paginate trims messages fetched with timelinePage down to perPage and returns
+// the links to the older and newer pages, which are empty if there are none.
+func paginate(r *http.Request, page ctrl.Page, messages []ctrl.Message) ([]ctrl.Message, string, string) {
+	more := len(messages) > perPage
 
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error setting up timeline: %s\n", err)
+	if more && page.After != nil {
+		messages = messages[1:]
+	} else if more {
+		messages = messages[:perPage]
+	}
+
+	if len(messages) == 0 {
+		return messages, "", ""
 	}
-	return tmpl
+
+	var older, newer string
+
+	if more || page.After != nil {
+		older = r.URL.Path + "?before=" + ctrl.CursorOf(messages[len(messages)-1]).String()
+	}
+
+	if page.Before != nil || (more && page.After != nil) {
+		newer = r.URL.Path + "?after=" + ctrl.CursorOf(messages[0]).String()
+	}
+
+	return messages, older, newer
 }
 
-func timeline(w http.ResponseWriter, r *http.Request) {
-	var tmpl *template.Template
+func (a *App) getMessages(w http.ResponseWriter, r *http.Request, page ctrl.Page, public bool, own bool) ([]ctrl.Message, error) {
+	_, user := a.getUserSession(w, r)
+
+	if public {
+		return a.storesFor(r).Messages.GetPublicMessages(page)
+	} else if own {
+		return a.storesFor(r).Messages.GetTimelineMessages(user.ID, page)
+	}
 
-	_, user := getUserSession(w, r)
+	profileUser, err := a.storesFor(r).Users.GetUserByUsername(mux.Vars(r)["username"])
+
+	if errors.Is(err, ctrl.ErrNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return a.storesFor(r).Messages.GetUserMessages(profileUser.ID, page)
+}
+
+// Title is the heading of the timeline page.
+func (d TimelineData) Title() string {
+	if d.RequestUrl == "/public" {
+		return "Public Timeline"
+	} else if d.Mentions {
+		return "Mentions of " + d.Profile_User.Username
+	} else if d.Likes {
+		return "Liked by " + d.Profile_User.Username
+	} else if d.Tag != "" {
+		return "#" + d.Tag
+	} else if d.UserTimeline() {
+		return d.Profile_User.Username + "'s Timeline"
+	}
+
+	return "My Timeline"
+}
+
+// UserTimeline reports whether the page shows a single user's messages.
+func (d TimelineData) UserTimeline() bool {
+	return d.Profile_User.Username != "" && !d.Mentions && !d.Likes
+}
+
+func (a *App) timeline(w http.ResponseWriter, r *http.Request) {
+	_, user := a.getUserSession(w, r)
 
 	if user.Username == "" {
 		http.Redirect(w, r, "/public", http.StatusSeeOther)
 		return
 	}
-	// offset?
 
-	messages, err := getMessages(w, r, false, true)
+	page, err := timelinePage(r)
 
-	data := TimelineData{
-		RequestUrl:  r.URL.Path,
-		Messages:    messages,
-		SessionData: SessionData{User: ctrl.User{Username: user.Username}},
+	if err != nil {
+		w.WriteHeader(400)
+		return
 	}
 
+	messages, err := a.getMessages(w, r, page, false, true)
+
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "timeline: Error fetching messages: %s\n", err)
+		logging.FromRequest(r).Error("Error fetching messages", "func", "timeline", "error", err)
 		w.WriteHeader(500)
 		return
 	}
 
-	tmpl = setupTimelineTemplates(data)
-	tmpl.Execute(w, data)
+	messages, older, newer := paginate(r, page, messages)
+
+	data := TimelineData{
+		RequestUrl:  r.URL.Path,
+		Messages:    messages,
+		OlderUrl:    older,
+		NewerUrl:    newer,
+		Liked:       a.likedMessages(r, user.ID, messages),
+		Trending:    a.trendingTags(r),
+		SessionData: SessionData{User: user},
+	}
+
+	a.templates.Render(w, r, "timeline.html", data)
 }
 
-func publicTimeline(w http.ResponseWriter, r *http.Request) {
-	messages, err := getMessages(w, r, true, false)
+func (a *App) publicTimeline(w http.ResponseWriter, r *http.Request) {
+	page, err := timelinePage(r)
+
+	if err != nil {
+		w.WriteHeader(400)
+		return
+	}
+
+	messages, err := a.getMessages(w, r, page, true, false)
 
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "publicTimeline: Error fetching messages: %s\n", err)
+		logging.FromRequest(r).Error("Error fetching messages", "func", "publicTimeline", "error", err)
 		w.WriteHeader(500)
 		return
 	}
 
-	_, user := getUserSession(w, r)
+	messages, older, newer := paginate(r, page, messages)
+	_, user := a.getUserSession(w, r)
 
 	data := TimelineData{
 		RequestUrl:  r.URL.Path,
 		Messages:    messages,
-		SessionData: SessionData{User: ctrl.User{Username: user.Username}},
+		OlderUrl:    older,
+		NewerUrl:    newer,
+		Liked:       a.likedMessages(r, user.ID, messages),
+		Trending:    a.trendingTags(r),
+		SessionData: SessionData{User: user},
 	}
 
-	tmpl := setupTimelineTemplates(data)
-	tmpl.Execute(w, data)
+	a.templates.Render(w, r, "timeline.html", data)
 }
 
-func userTimeline(w http.ResponseWriter, r *http.Request) {
+func (a *App) userTimeline(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 
-	var profileUser ctrl.User
-	queryCheck := db.First(&profileUser, "username = ?", vars["username"])
+	profileUser, err := a.storesFor(r).Users.GetUserByUsername(vars["username"])
 
-	if queryCheck.Error != nil {
-		if errors.Is(queryCheck.Error, gorm.ErrRecordNotFound) {
+	if err != nil {
+		if errors.Is(err, ctrl.ErrNotFound) {
 			w.WriteHeader(404)
 			return
 		}
 
-		fmt.Fprintf(os.Stderr, "userTimeline: Error in database lookup: %s\n", queryCheck.Error)
+		logging.FromRequest(r).Error("Error in database lookup", "func", "userTimeline", "error", err)
 		w.WriteHeader(500)
 		return
 	}
 
-	_, user := getUserSession(w, r)
+	_, user := a.getUserSession(w, r)
 	followed := true
 
 	if user.ID != 0 {
-		var follow ctrl.Follower
-		query := db.First(&follow, "follower_id = ? AND follows_id = ?", user.ID, profileUser.ID)
-
-		if query.Error != nil {
-			if errors.Is(query.Error, gorm.ErrRecordNotFound) {
-				followed = false
-			} else {
-				fmt.Fprintf(os.Stderr, "userTimeline: Error in database lookup: %s\n", query.Error)
-				w.WriteHeader(500)
-				return
-			}
+		followed, err = a.storesFor(r).Follows.IsFollowing(user.ID, profileUser.ID)
+
+		if err != nil {
+			logging.FromRequest(r).Error("Error in database lookup", "func", "userTimeline", "error", err)
+			w.WriteHeader(500)
+			return
 		}
 	}
 
-	messages, err := getMessages(w, r, false, false)
+	page, err := timelinePage(r)
+
+	if err != nil {
+		w.WriteHeader(400)
+		return
+	}
+
+	messages, err := a.getMessages(w, r, page, false, false)
 
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "userTimeline: Error getting messages: %s\n", err)
+		logging.FromRequest(r).Error("Error getting messages", "func", "userTimeline", "error", err)
 		w.WriteHeader(500)
 		return
 	}
 
+	messages, older, newer := paginate(r, page, messages)
+
 	data := TimelineData{
 		RequestUrl:   r.URL.Path,
 		Followed:     followed,
 		Messages:     messages,
+		OlderUrl:     older,
+		NewerUrl:     newer,
 		Profile_User: ctrl.User{Username: profileUser.Username},
-		SessionData:  SessionData{User: ctrl.User{Username: user.Username}},
+		Liked:        a.likedMessages(r, user.ID, messages),
+		Trending:     a.trendingTags(r),
+		SessionData:  SessionData{User: user},
 	}
 
-	tmpl := setupTimelineTemplates(data)
-	tmpl.Execute(w, data)
+	a.templates.Render(w, r, "timeline.html", data)
 }
 
-func follow(w http.ResponseWriter, r *http.Request) {
-	session, user := getUserSession(w, r)
+func (a *App) mentionsTimeline(w http.ResponseWriter, r *http.Request) {
+	profileUser, err := a.storesFor(r).Users.GetUserByUsername(mux.Vars(r)["username"])
+
+	if err != nil {
+		if errors.Is(err, ctrl.ErrNotFound) {
+			w.WriteHeader(404)
+			return
+		}
+
+		logging.FromRequest(r).Error("Error in database lookup", "func", "mentionsTimeline", "error", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	page, err := timelinePage(r)
+
+	if err != nil {
+		w.WriteHeader(400)
+		return
+	}
+
+	messages, err := a.storesFor(r).Messages.GetMentionMessages(profileUser.ID, page)
+
+	if err != nil {
+		logging.FromRequest(r).Error("Error getting messages", "func", "mentionsTimeline", "error", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	messages, older, newer := paginate(r, page, messages)
+	_, user := a.getUserSession(w, r)
+
+	data := TimelineData{
+		RequestUrl:   r.URL.Path,
+		Messages:     messages,
+		OlderUrl:     older,
+		NewerUrl:     newer,
+		Mentions:     true,
+		Profile_User: ctrl.User{Username: profileUser.Username},
+		Liked:        a.likedMessages(r, user.ID, messages),
+		Trending:     a.trendingTags(r),
+		SessionData:  SessionData{User: user},
+	}
+
+	a.templates.Render(w, r, "timeline.html", data)
+}
+
+func (a *App) follow(w http.ResponseWriter, r *http.Request) {
+	session, user := a.getUserSession(w, r)
 
 	if user.Username == "" {
 		w.WriteHeader(401)
@@ -324,28 +589,28 @@ func follow(w http.ResponseWriter, r *http.Request) {
 	}
 
 	vars := mux.Vars(r)
-	followsID := ctrl.GetUserID(vars["username"], db)
+	followsID := a.storesFor(r).Users.GetUserID(vars["username"])
 
 	if followsID == 0 {
 		w.WriteHeader(404)
 		return
 	}
 
-	query := db.Create(&ctrl.Follower{FollowerID: user.ID, FollowsID: followsID})
-
-	if query.Error != nil {
-		fmt.Fprintf(os.Stderr, "follow: Error in creating database record: %s\n", query.Error)
+	if err := a.storesFor(r).Follows.Follow(user.ID, followsID); err != nil {
+		logging.FromRequest(r).Error("Error in creating database record", "func", "follow", "error", err)
 		w.WriteHeader(500)
 		return
 	}
 
-	session.AddFlash("You are now following %s", vars["username"])
+	mntr.Followed(mntr.SourceApp)
+
+	session.AddFlash(fmt.Sprintf("You are now following %s", vars["username"]))
 	str := "/" + vars["username"]
 	http.Redirect(w, r, str, http.StatusSeeOther)
 }
 
-func unfollow(w http.ResponseWriter, r *http.Request) {
-	session, user := getUserSession(w, r)
+func (a *App) unfollow(w http.ResponseWriter, r *http.Request) {
+	session, user := a.getUserSession(w, r)
 
 	if user.Username == "" {
 		w.WriteHeader(401)
@@ -353,29 +618,29 @@ func unfollow(w http.ResponseWriter, r *http.Request) {
 	}
 
 	vars := mux.Vars(r)
-	followsID := ctrl.GetUserID(vars["username"], db)
+	followsID := a.storesFor(r).Users.GetUserID(vars["username"])
 
 	if followsID == 0 {
 		w.WriteHeader(404)
 		return
 	}
 
-	query := db.Where("follower_id = ? AND follows_id = ?", user.ID, followsID).Delete(&ctrl.Follower{})
-
-	if query.Error != nil && !errors.Is(query.Error, gorm.ErrRecordNotFound) {
-		fmt.Fprintf(os.Stderr, "unfollow: Error in database lookup: %s\n", query.Error)
+	if err := a.storesFor(r).Follows.Unfollow(user.ID, followsID); err != nil {
+		logging.FromRequest(r).Error("Error in database lookup", "func", "unfollow", "error", err)
 		w.WriteHeader(500)
 		return
 	}
 
-	session.AddFlash("You are no longer following %s", vars["username"])
+	mntr.Unfollowed(mntr.SourceApp)
+
+	session.AddFlash(fmt.Sprintf("You are no longer following %s", vars["username"]))
 	session.Save(r, w)
 	str := "/" + vars["username"]
 	http.Redirect(w, r, str, http.StatusSeeOther)
 }
 
-func addMessage(w http.ResponseWriter, r *http.Request) {
-	session, user := getUserSession(w, r)
+func (a *App) addMessage(w http.ResponseWriter, r *http.Request) {
+	session, user := a.getUserSession(w, r)
 	text := r.FormValue("text")
 
 	if user.ID == 0 {
@@ -383,29 +648,177 @@ func addMessage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if text != "" {
-		query := db.Create(&ctrl.Message{
-			AuthorID: user.ID,
-			Text:     text,
-			Date:     time.Now().Unix(),
-			Flagged:  0,
-		})
+	author, err := a.storesFor(r).Users.GetUserByID(user.ID)
 
-		if query.Error != nil {
-			fmt.Fprintf(os.Stderr, "addMessage: Error in creating database record: %s\n", query.Error)
+	if err != nil {
+		logging.FromRequest(r).Error("Error in database lookup", "func", "addMessage", "error", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	if author.Suspended {
+		session.AddFlash("Your account has been suspended")
+		session.Save(r, w)
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	var inReplyTo uint
+
+	if val := r.FormValue("in_reply_to"); val != "" {
+		id, _ := strconv.ParseUint(val, 10, 0)
+		parent, err := a.storesFor(r).Messages.GetMessage(uint(id))
+
+		if errors.Is(err, ctrl.ErrNotFound) || (err == nil && parent.Flagged != 0) {
+			session.AddFlash("The message you replied to no longer exists")
+			session.Save(r, w)
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		} else if err != nil {
+			logging.FromRequest(r).Error("Error in database lookup", "func", "addMessage", "error", err)
 			w.WriteHeader(500)
 			return
 		}
 
-		session.AddFlash("Your message was recorded")
-		session.Save(r, w)
+		inReplyTo = parent.ID
+	}
+
+	if text == "" {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	msg := &ctrl.Message{
+		AuthorID:  user.ID,
+		Text:      text,
+		Date:      time.Now().Unix(),
+		Flagged:   0,
+		InReplyTo: inReplyTo,
+	}
+
+	if err := a.storesFor(r).Messages.CreateMessage(msg); err != nil {
+		logging.FromRequest(r).Error("Error in creating database record", "func", "addMessage", "error", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	mntr.MessagePosted(mntr.SourceApp)
+
+	session.AddFlash("Your message was recorded")
+	session.Save(r, w)
+
+	// Replies are shown in their conversation
+	if inReplyTo != 0 {
+		http.Redirect(w, r, fmt.Sprintf("/%s/status/%d", user.Username, msg.ID), http.StatusSeeOther)
+		return
 	}
 
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
-func login(w http.ResponseWriter, r *http.Request) {
-	session, user := getUserSession(w, r)
+// ownMessage looks up the message addressed by the request's id, writing an
+// error unless it was written by user.
+func (a *App) ownMessage(w http.ResponseWriter, r *http.Request, user ctrl.User) (ctrl.Message, bool) {
+	if user.ID == 0 {
+		w.WriteHeader(401)
+		return ctrl.Message{}, false
+	}
+
+	id, _ := strconv.ParseUint(mux.Vars(r)["id"], 10, 0)
+	msg, err := a.storesFor(r).Messages.GetMessage(uint(id))
+
+	if errors.Is(err, ctrl.ErrNotFound) || (err == nil && msg.Flagged != 0) {
+		w.WriteHeader(404)
+		return msg, false
+	} else if err != nil {
+		logging.FromRequest(r).Error("Error in database lookup", "func", "ownMessage", "error", err)
+		w.WriteHeader(500)
+		return msg, false
+	} else if msg.AuthorID != user.ID {
+		w.WriteHeader(403)
+		return msg, false
+	}
+
+	return msg, true
+}
+
+func (a *App) editMessage(w http.ResponseWriter, r *http.Request) {
+	session, user := a.getUserSession(w, r)
+	msg, ok := a.ownMessage(w, r, user)
+
+	if !ok {
+		return
+	}
+
+	var error string
+	if r.Method == "POST" {
+		text := r.FormValue("text")
+
+		if text == "" {
+			error = "You have to enter a message"
+		} else {
+			if text != msg.Text {
+				err := a.storesFor(r).Messages.UpdateMessage(msg.ID, text, time.Now().Unix())
+
+				if err != nil {
+					logging.FromRequest(r).Error("Error in updating database record", "func", "editMessage", "error", err)
+					w.WriteHeader(500)
+					return
+				}
+
+				session.AddFlash("Your message was updated")
+				session.Save(r, w)
+			}
+
+			http.Redirect(w, r, "/"+user.Username, http.StatusSeeOther)
+			return
+		}
+	}
+
+	revisions, err := a.storesFor(r).Messages.GetRevisions(msg.ID)
+
+	if err != nil {
+		logging.FromRequest(r).Error("Error in database lookup", "func", "editMessage", "error", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	data := struct {
+		Error       string
+		Message     ctrl.Message
+		Revisions   []ctrl.MessageRevision
+		SessionData SessionData
+	}{
+		Error:       error,
+		Message:     msg,
+		Revisions:   revisions,
+		SessionData: SessionData{User: user, Flashes: session.Flashes()},
+	}
+
+	a.templates.Render(w, r, "edit.html", data)
+}
+
+func (a *App) deleteMessage(w http.ResponseWriter, r *http.Request) {
+	session, user := a.getUserSession(w, r)
+	msg, ok := a.ownMessage(w, r, user)
+
+	if !ok {
+		return
+	}
+
+	if err := a.storesFor(r).Messages.DeleteMessage(msg.ID); err != nil {
+		logging.FromRequest(r).Error("Error in deleting database record", "func", "deleteMessage", "error", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	session.AddFlash("Your message was deleted")
+	session.Save(r, w)
+	http.Redirect(w, r, "/"+user.Username, http.StatusSeeOther)
+}
+
+func (a *App) login(w http.ResponseWriter, r *http.Request) {
+	session, user := a.getUserSession(w, r)
 	user_id := user.ID
 	if user_id != 0 {
 		http.Redirect(w, r, "/", http.StatusSeeOther)
@@ -417,31 +830,37 @@ func login(w http.ResponseWriter, r *http.Request) {
 		inputUsername := r.FormValue("username")
 		inputPassword := r.FormValue("password")
 
-		var user ctrl.User
-		query := db.First(&user, "username = ?", inputUsername)
+		user, err := a.storesFor(r).Users.GetUserByUsername(inputUsername)
+
+		if errors.Is(err, ctrl.ErrNotFound) {
+			error = "Invalid username"
+		} else if err != nil {
+			logging.FromRequest(r).Error("Error in database lookup", "func", "login", "error", err)
+			error = "Something went wrong"
+		} else if !ctrl.CheckPwHash(inputPassword, user.PwHash) {
+			error = "Invalid password"
+		} else if user.Suspended {
+			error = "Your account has been suspended"
+		} else {
+			mntr.LoginSucceeded(mntr.SourceApp)
 
-		if query.Error != nil {
-			if errors.Is(query.Error, gorm.ErrRecordNotFound) {
-				error = "Invalid username"
-			} else if !checkPwHash(inputPassword, user.PwHash) {
-				error = "Invalid password"
-			} else {
-				error = "Something went wrong"
+			// A fresh session key prevents session fixation.
+			if err := a.sessions.Renew(session); err != nil {
+				logging.FromRequest(r).Error("Error in deleting database record", "func", "login", "error", err)
 			}
-		} else {
+
 			session.AddFlash("You were logged in")
 			session.Values["user_id"] = user.ID
 			session.Values["username"] = user.Username
+			session.Values["moderator"] = user.Moderator
 			session.Save(r, w)
 			http.Redirect(w, r, "/", http.StatusSeeOther)
 			return
 		}
-	}
 
-	tmpl, err := template.ParseFiles("static/login.html", "static/layout.html")
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "login: Error in parsing HTML: %s\n", err)
+		mntr.LoginFailed(mntr.SourceApp)
 	}
+
 	data := struct {
 		Error       string
 		SessionData SessionData
@@ -450,11 +869,11 @@ func login(w http.ResponseWriter, r *http.Request) {
 		SessionData: SessionData{Flashes: session.Flashes()},
 	}
 
-	tmpl.Execute(w, data)
+	a.templates.Render(w, r, "login.html", data)
 }
 
-func register(w http.ResponseWriter, r *http.Request) {
-	session, _ := store.Get(r, "user-session")
+func (a *App) register(w http.ResponseWriter, r *http.Request) {
+	session, _ := a.sessions.Get(r, "user-session")
 	user_id := session.Values["user_id"]
 	if user_id != nil {
 		http.Redirect(w, r, "/", http.StatusSeeOther)
@@ -467,7 +886,7 @@ func register(w http.ResponseWriter, r *http.Request) {
 		inputEmail := r.FormValue("email")
 		inputPassword := r.FormValue("password")
 		inputRepeatPassword := r.FormValue("password2")
-		userID := ctrl.GetUserID(inputUsername, db)
+		userID := a.storesFor(r).Users.GetUserID(inputUsername)
 
 		if inputUsername == "" {
 			error = "You have to enter a username"
@@ -482,37 +901,31 @@ func register(w http.ResponseWriter, r *http.Request) {
 		} else {
 			hashed_pw, err := ctrl.HashPw(inputPassword)
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "register: Error in password hashing: %s\n", err)
+				logging.FromRequest(r).Error("Error in password hashing", "func", "register", "error", err)
 				w.WriteHeader(500)
 				return
 			}
 
-			query := db.Create(&ctrl.User{
+			err = a.storesFor(r).Users.CreateUser(&ctrl.User{
 				Username: inputUsername,
 				Email:    inputEmail,
 				PwHash:   hashed_pw,
 			})
 
-			if query.Error != nil {
-				fmt.Fprintf(os.Stderr, "register: Error in creating database record: %s\n", query.Error)
+			if err != nil {
+				logging.FromRequest(r).Error("Error in creating database record", "func", "register", "error", err)
 				w.WriteHeader(500)
 				return
 			}
 
+			mntr.UserRegistered(mntr.SourceApp)
+
 			session.AddFlash("You were successfully registered and can login now")
 			session.Save(r, w)
 			http.Redirect(w, r, "/login", http.StatusSeeOther)
 			return
 		}
 	}
-	tmpl, err := template.ParseFiles("static/register.html", "static/layout.html")
-
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "register: Error in parsing HTML: %s\n", err)
-		w.WriteHeader(500)
-		return
-	}
-
 	data := struct {
 		Error       string
 		SessionData SessionData
@@ -520,25 +933,26 @@ func register(w http.ResponseWriter, r *http.Request) {
 		Error:       error,
 		SessionData: SessionData{Flashes: session.Flashes()},
 	}
-	tmpl.Execute(w, data)
+	a.templates.Render(w, r, "register.html", data)
 }
 
-func logout(w http.ResponseWriter, r *http.Request) {
-	session, _ := store.Get(r, "user-session")
+func (a *App) logout(w http.ResponseWriter, r *http.Request) {
+	session, _ := a.sessions.Get(r, "user-session")
+
+	// Revoke the old session key; the flash is kept under a fresh one.
+	if err := a.sessions.Renew(session); err != nil {
+		logging.FromRequest(r).Error("Error in deleting database record", "func", "logout", "error", err)
+	}
+
 	session.AddFlash("You were logged out")
-	clearUserSessionData(w, r)
+	a.clearUserSessionData(w, r)
 	http.Redirect(w, r, "/public", http.StatusSeeOther)
 }
 
-func clearUserSessionData(w http.ResponseWriter, r *http.Request) {
-	session, _ := store.Get(r, "user-session")
+func (a *App) clearUserSessionData(w http.ResponseWriter, r *http.Request) {
+	session, _ := a.sessions.Get(r, "user-session")
 	delete(session.Values, "user_id")  //session.Values["user_id"] = nil
 	delete(session.Values, "username") //session.Values["username"] = nil
+	delete(session.Values, "moderator")
 	session.Save(r, w)
 }
-
-// The function below has been copied from: https://gowebexamples.com/password-hashing/
-func checkPwHash(password, hash string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
-	return err == nil
-}
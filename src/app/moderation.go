@@ -0,0 +1,210 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/sessions"
+
+	ctrl "minitwit/controllers"
+	"minitwit/logging"
+)
+
+// requireModerator writes an error unless the logged in user is a moderator.
+// The role is checked against the database, as the session may be stale.
+func (a *App) requireModerator(w http.ResponseWriter, r *http.Request) (*sessions.Session, ctrl.User, bool) {
+	session, user := a.getUserSession(w, r)
+
+	if user.ID == 0 {
+		w.WriteHeader(401)
+		return session, user, false
+	}
+
+	user, err := a.storesFor(r).Users.GetUserByID(user.ID)
+
+	if err != nil {
+		logging.FromRequest(r).Error("Error in database lookup", "func", "requireModerator", "error", err)
+		w.WriteHeader(500)
+		return session, user, false
+	}
+
+	if !user.Moderator {
+		w.WriteHeader(403)
+		return session, user, false
+	}
+
+	return session, user, true
+}
+
+func (a *App) reportMessage(w http.ResponseWriter, r *http.Request) {
+	session, user := a.getUserSession(w, r)
+
+	if user.ID == 0 {
+		w.WriteHeader(401)
+		return
+	}
+
+	id, _ := strconv.ParseUint(mux.Vars(r)["id"], 10, 0)
+	msg, err := a.storesFor(r).Messages.GetMessage(uint(id))
+
+	if errors.Is(err, ctrl.ErrNotFound) || (err == nil && msg.Flagged != ctrl.FlagNone) {
+		w.WriteHeader(404)
+		return
+	} else if err != nil {
+		logging.FromRequest(r).Error("Error in database lookup", "func", "reportMessage", "error", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	var error string
+	if r.Method == "POST" {
+		reason := r.FormValue("reason")
+
+		if reason == "" {
+			error = "You have to enter a reason"
+		} else {
+			err := a.storesFor(r).Moderation.ReportMessage(&ctrl.Report{
+				MessageID:  msg.ID,
+				ReporterID: user.ID,
+				Reason:     reason,
+				Date:       time.Now().Unix(),
+			})
+
+			if err != nil {
+				logging.FromRequest(r).Error("Error in creating database record", "func", "reportMessage", "error", err)
+				w.WriteHeader(500)
+				return
+			}
+
+			session.AddFlash("Thank you, a moderator will look at the message")
+			session.Save(r, w)
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+	}
+
+	data := struct {
+		Error       string
+		Message     ctrl.Message
+		SessionData SessionData
+	}{
+		Error:       error,
+		Message:     msg,
+		SessionData: SessionData{User: user, Flashes: session.Flashes()},
+	}
+
+	a.templates.Render(w, r, "report.html", data)
+}
+
+func (a *App) moderationQueue(w http.ResponseWriter, r *http.Request) {
+	session, user, ok := a.requireModerator(w, r)
+
+	if !ok {
+		return
+	}
+
+	reports, err := a.storesFor(r).Moderation.GetOpenReports()
+
+	if err != nil {
+		logging.FromRequest(r).Error("Error in database lookup", "func", "moderationQueue", "error", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	flagged, err := a.storesFor(r).Moderation.GetFlaggedMessages()
+
+	if err != nil {
+		logging.FromRequest(r).Error("Error in database lookup", "func", "moderationQueue", "error", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	data := struct {
+		Reports     []ctrl.ReportedMessage
+		Flagged     []ctrl.ReportedMessage
+		SessionData SessionData
+	}{
+		Reports:     reports,
+		Flagged:     flagged,
+		SessionData: SessionData{User: user, Flashes: session.Flashes()},
+	}
+
+	a.templates.Render(w, r, "moderation.html", data)
+}
+
+func (a *App) moderateMessage(w http.ResponseWriter, r *http.Request) {
+	session, user, ok := a.requireModerator(w, r)
+
+	if !ok {
+		return
+	}
+
+	vars := mux.Vars(r)
+	id, _ := strconv.ParseUint(vars["id"], 10, 0)
+	var err error
+
+	switch vars["action"] {
+	case "flag":
+		reason := r.FormValue("reason")
+
+		if reason == "" {
+			reason = "Flagged by a moderator"
+		}
+
+		err = a.storesFor(r).Moderation.FlagMessage(uint(id), user.ID, reason, time.Now().Unix())
+	case "unflag":
+		err = a.storesFor(r).Moderation.UnflagMessage(uint(id))
+	case "dismiss":
+		err = a.storesFor(r).Moderation.DismissReports(uint(id))
+	}
+
+	if errors.Is(err, ctrl.ErrNotFound) {
+		w.WriteHeader(404)
+		return
+	} else if err != nil {
+		logging.FromRequest(r).Error("Error in updating database record", "func", "moderateMessage", "error", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	session.AddFlash("The message was updated")
+	session.Save(r, w)
+	http.Redirect(w, r, "/moderation", http.StatusSeeOther)
+}
+
+func (a *App) suspendUser(w http.ResponseWriter, r *http.Request) {
+	session, _, ok := a.requireModerator(w, r)
+
+	if !ok {
+		return
+	}
+
+	vars := mux.Vars(r)
+	userID := a.storesFor(r).Users.GetUserID(vars["username"])
+
+	if userID == 0 {
+		w.WriteHeader(404)
+		return
+	}
+
+	suspend := vars["action"] == "suspend"
+
+	if err := a.storesFor(r).Moderation.SetSuspended(userID, suspend); err != nil {
+		logging.FromRequest(r).Error("Error in updating database record", "func", "suspendUser", "error", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	if suspend {
+		session.AddFlash(fmt.Sprintf("%s has been suspended", vars["username"]))
+	} else {
+		session.AddFlash(fmt.Sprintf("%s has been reinstated", vars["username"]))
+	}
+
+	session.Save(r, w)
+	http.Redirect(w, r, "/moderation", http.StatusSeeOther)
+}
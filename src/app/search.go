@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+
+	ctrl "minitwit/controllers"
+	"minitwit/logging"
+)
+
+// maxSearchPage bounds the page parameter of the search, so that its offset
+// stays far from overflowing.
+const maxSearchPage = 1000
+
+// search shows the users and messages matching the q parameter, most relevant
+// first. Both lists are paged together by the page parameter, counting from 1.
+func (a *App) search(w http.ResponseWriter, r *http.Request) {
+	_, user := a.getUserSession(w, r)
+	query := r.URL.Query().Get("q")
+	page := 1
+
+	if p := r.URL.Query().Get("page"); p != "" {
+		n, err := strconv.Atoi(p)
+
+		if err != nil || n < 1 {
+			w.WriteHeader(400)
+			return
+		}
+
+		page = n
+
+		if page > maxSearchPage {
+			page = maxSearchPage
+		}
+	}
+
+	// One result more than is shown tells whether there is a next page
+	offset := (page - 1) * perPage
+	users, err := a.storesFor(r).Search.SearchUsers(query, perPage+1, offset)
+
+	if err != nil {
+		logging.FromRequest(r).Error("Error in database lookup", "func", "search", "error", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	messages, err := a.storesFor(r).Search.SearchMessages(query, perPage+1, offset)
+
+	if err != nil {
+		logging.FromRequest(r).Error("Error in database lookup", "func", "search", "error", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	pageUrl := func(n int) string {
+		return r.URL.Path + "?" + url.Values{"q": {query}, "page": {strconv.Itoa(n)}}.Encode()
+	}
+
+	data := struct {
+		Query       string
+		Users       []ctrl.User
+		Messages    []ctrl.Message
+		PrevUrl     string
+		NextUrl     string
+		SessionData SessionData
+	}{
+		Query:       query,
+		Users:       users,
+		Messages:    messages,
+		SessionData: SessionData{User: user},
+	}
+
+	if (len(users) > perPage || len(messages) > perPage) && page < maxSearchPage {
+		data.NextUrl = pageUrl(page + 1)
+	}
+
+	if len(users) > perPage {
+		data.Users = users[:perPage]
+	}
+
+	if len(messages) > perPage {
+		data.Messages = messages[:perPage]
+	}
+
+	if page > 1 {
+		data.PrevUrl = pageUrl(page - 1)
+	}
+
+	a.templates.Render(w, r, "search.html", data)
+}
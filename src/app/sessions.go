@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	ctrl "minitwit/controllers"
+	"minitwit/logging"
+)
+
+// sessionPurgeInterval is how often expired sessions are removed from the
+// database.
+const sessionPurgeInterval = time.Hour
+
+func (a *App) purgeSessions() {
+	for range time.Tick(sessionPurgeInterval) {
+		if err := a.stores.Sessions.DeleteExpiredSessions(time.Now().Unix()); err != nil {
+			logging.Error("Error in deleting database records", "func", "purgeSessions", "error", err)
+		}
+	}
+}
+
+func (a *App) activeSessions(w http.ResponseWriter, r *http.Request) {
+	session, user := a.getUserSession(w, r)
+
+	if user.ID == 0 {
+		w.WriteHeader(401)
+		return
+	}
+
+	list, err := a.storesFor(r).Sessions.GetUserSessions(user.ID, time.Now().Unix())
+
+	if err != nil {
+		logging.FromRequest(r).Error("Error in database lookup", "func", "activeSessions", "error", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	data := struct {
+		Sessions    []ctrl.Session
+		Current     string
+		SessionData SessionData
+	}{
+		Sessions:    list,
+		Current:     ctrl.SessionHash(session),
+		SessionData: SessionData{User: user, Flashes: session.Flashes()},
+	}
+
+	a.templates.Render(w, r, "sessions.html", data)
+}
+
+func (a *App) revokeSession(w http.ResponseWriter, r *http.Request) {
+	session, user := a.getUserSession(w, r)
+
+	if user.ID == 0 {
+		w.WriteHeader(401)
+		return
+	}
+
+	id, _ := strconv.ParseUint(mux.Vars(r)["id"], 10, 0)
+	err := a.storesFor(r).Sessions.DeleteUserSession(user.ID, uint(id))
+
+	if errors.Is(err, ctrl.ErrNotFound) {
+		w.WriteHeader(404)
+		return
+	} else if err != nil {
+		logging.FromRequest(r).Error("Error in deleting database record", "func", "revokeSession", "error", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	session.AddFlash("The session was signed out")
+	session.Save(r, w)
+	http.Redirect(w, r, "/sessions", http.StatusSeeOther)
+}
+
+func (a *App) revokeOtherSessions(w http.ResponseWriter, r *http.Request) {
+	session, user := a.getUserSession(w, r)
+
+	if user.ID == 0 {
+		w.WriteHeader(401)
+		return
+	}
+
+	if err := a.storesFor(r).Sessions.DeleteUserSessions(user.ID, ctrl.SessionHash(session)); err != nil {
+		logging.FromRequest(r).Error("Error in deleting database records", "func", "revokeOtherSessions", "error", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	session.AddFlash("All other sessions were signed out")
+	session.Save(r, w)
+	http.Redirect(w, r, "/sessions", http.StatusSeeOther)
+}
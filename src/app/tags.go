@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	ctrl "minitwit/controllers"
+	"minitwit/logging"
+)
+
+const (
+	trendingLimit = 10
+	// trendingTTL is how long the trending tags are cached, since every
+	// timeline page shows them.
+	trendingTTL = time.Minute
+)
+
+// trendingCache holds the trending tags between refreshes.
+type trendingCache struct {
+	mu      sync.Mutex
+	tags    []ctrl.TagCount
+	expires time.Time
+}
+
+// trendingTags returns the most used tags of the last ctrl.TrendingWindow. On
+// errors the sidebar is left out rather than failing the page.
+func (a *App) trendingTags(r *http.Request) []ctrl.TagCount {
+	a.trending.mu.Lock()
+	defer a.trending.mu.Unlock()
+
+	if time.Now().Before(a.trending.expires) {
+		return a.trending.tags
+	}
+
+	since := time.Now().Add(-ctrl.TrendingWindow).Unix()
+	tags, err := a.storesFor(r).Messages.GetTrendingTags(since, trendingLimit)
+
+	if err != nil {
+		logging.FromRequest(r).Error("Error in database lookup", "func", "trendingTags", "error", err)
+		return nil
+	}
+
+	a.trending.tags = tags
+	a.trending.expires = time.Now().Add(trendingTTL)
+	return tags
+}
+
+func (a *App) tagTimeline(w http.ResponseWriter, r *http.Request) {
+	tag := strings.ToLower(mux.Vars(r)["name"])
+
+	if !ctrl.ValidTag(tag) {
+		w.WriteHeader(404)
+		return
+	}
+
+	page, err := timelinePage(r)
+
+	if err != nil {
+		w.WriteHeader(400)
+		return
+	}
+
+	messages, err := a.storesFor(r).Messages.GetTagMessages(tag, page)
+
+	if err != nil {
+		logging.FromRequest(r).Error("Error getting messages", "func", "tagTimeline", "error", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	messages, older, newer := paginate(r, page, messages)
+	_, user := a.getUserSession(w, r)
+
+	data := TimelineData{
+		RequestUrl:  r.URL.Path,
+		Messages:    messages,
+		OlderUrl:    older,
+		NewerUrl:    newer,
+		Tag:         tag,
+		Liked:       a.likedMessages(r, user.ID, messages),
+		Trending:    a.trendingTags(r),
+		SessionData: SessionData{User: user},
+	}
+
+	a.templates.Render(w, r, "timeline.html", data)
+}
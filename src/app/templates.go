@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"minitwit/logging"
+)
+
+// static holds the templates and stylesheets, so the binary does not depend
+// on its working directory.
+//
+//go:embed static
+var static embed.FS
+
+// pages are the templates under static/ that are rendered on their own. Each
+// is parsed after layout.html, so that its blocks replace the defaults there.
+var pages = []string{
+	"edit.html",
+	"login.html",
+	"moderation.html",
+	"register.html",
+	"report.html",
+	"search.html",
+	"sessions.html",
+	"thread.html",
+	"timeline.html",
+}
+
+var templateFuncs = template.FuncMap{
+	"format_datetime": formatDatetime,
+	"format_message":  formatMessage,
+	"gravatar_url":    gravatarUrl,
+}
+
+// Templates holds the parsed pages. In dev mode the pages are read from disk
+// and parsed again whenever a file under the directory changes.
+type Templates struct {
+	mu       sync.RWMutex
+	files    fs.FS
+	dev      bool
+	parsed   map[string]*template.Template
+	modified time.Time
+}
+
+// NewTemplates parses all pages, either from the embedded static/ directory
+// or, in dev mode, from the static/ directory on disk.
+func NewTemplates(dev bool) (*Templates, error) {
+	t := &Templates{dev: dev}
+
+	if dev {
+		t.files = os.DirFS("static")
+	} else {
+		files, err := fs.Sub(static, "static")
+
+		if err != nil {
+			return nil, err
+		}
+
+		t.files = files
+	}
+
+	if err := t.parse(); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// Static returns the file system the templates are read from.
+func (t *Templates) Static() fs.FS {
+	return t.files
+}
+
+func (t *Templates) parse() error {
+	modified, err := t.lastModified()
+
+	if err != nil {
+		return err
+	}
+
+	parsed := make(map[string]*template.Template, len(pages))
+
+	for _, page := range pages {
+		tmpl, err := template.New(page).Funcs(templateFuncs).ParseFS(t.files, "layout.html", page)
+
+		if err != nil {
+			return fmt.Errorf("templates: parsing %s: %w", page, err)
+		}
+
+		parsed[page] = tmpl
+	}
+
+	t.mu.Lock()
+	t.parsed = parsed
+	t.modified = modified
+	t.mu.Unlock()
+	return nil
+}
+
+// lastModified returns the latest modification time of the HTML files.
+func (t *Templates) lastModified() (time.Time, error) {
+	var latest time.Time
+
+	matches, err := fs.Glob(t.files, "*.html")
+
+	if err != nil {
+		return latest, err
+	}
+
+	for _, name := range matches {
+		info, err := fs.Stat(t.files, name)
+
+		if err != nil {
+			return latest, err
+		}
+
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+
+	return latest, nil
+}
+
+// reload parses the templates again if a file changed since they were last
+// parsed. On a parse error the previous templates are kept.
+func (t *Templates) reload() error {
+	modified, err := t.lastModified()
+
+	if err != nil {
+		return err
+	}
+
+	t.mu.RLock()
+	stale := modified.After(t.modified)
+	t.mu.RUnlock()
+
+	if !stale {
+		return nil
+	}
+
+	return t.parse()
+}
+
+// Check fails unless every page has been parsed. It serves as the readiness
+// check for the templates.
+func (t *Templates) Check(ctx context.Context) error {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	for _, page := range pages {
+		if t.parsed[page] == nil {
+			return fmt.Errorf("templates: %s is not loaded", page)
+		}
+	}
+
+	return nil
+}
+
+// Render executes the named page with data. The page is rendered into a
+// buffer first, so that a failing template results in a clean 500.
+func (t *Templates) Render(w http.ResponseWriter, r *http.Request, name string, data interface{}) {
+	if t.dev {
+		if err := t.reload(); err != nil {
+			logging.FromRequest(r).Error("Error in reloading templates", "func", "Render", "error", err)
+		}
+	}
+
+	t.mu.RLock()
+	tmpl, ok := t.parsed[name]
+	t.mu.RUnlock()
+
+	if !ok {
+		logging.FromRequest(r).Error("Unknown template", "func", "Render", "template", name)
+		w.WriteHeader(500)
+		return
+	}
+
+	var buf bytes.Buffer
+
+	if err := tmpl.Execute(&buf, data); err != nil {
+		logging.FromRequest(r).Error("Error in executing template", "func", "Render", "template", name, "error", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	buf.WriteTo(w)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	ctrl "minitwit/controllers"
+	"minitwit/logging"
+)
+
+// maxThreadDepth caps the indentation of replies, so that long back and forth
+// conversations stay readable.
+const maxThreadDepth = 6
+
+// ThreadEntry is a message on the thread page with its depth in the
+// conversation tree. The first message of the conversation has depth 0.
+type ThreadEntry struct {
+	ctrl.Message
+	Depth int
+}
+
+// Indent is the left margin of the entry in em.
+func (e ThreadEntry) Indent() int {
+	if e.Depth > maxThreadDepth {
+		return maxThreadDepth * 2
+	}
+
+	return e.Depth * 2
+}
+
+// flattenThread orders the messages of a conversation, oldest first, so that
+// every reply follows the message it answers and its earlier siblings'
+// replies. Messages whose parent is missing, such as replies to flagged
+// messages, are shown at the top level.
+func flattenThread(messages []ctrl.Message) []ThreadEntry {
+	present := make(map[uint]bool, len(messages))
+	replies := make(map[uint][]ctrl.Message)
+
+	for _, m := range messages {
+		present[m.ID] = true
+	}
+
+	var roots []ctrl.Message
+
+	for _, m := range messages {
+		if present[m.InReplyTo] {
+			replies[m.InReplyTo] = append(replies[m.InReplyTo], m)
+		} else {
+			roots = append(roots, m)
+		}
+	}
+
+	entries := make([]ThreadEntry, 0, len(messages))
+
+	var walk func(m ctrl.Message, depth int)
+	walk = func(m ctrl.Message, depth int) {
+		entries = append(entries, ThreadEntry{Message: m, Depth: depth})
+
+		for _, reply := range replies[m.ID] {
+			walk(reply, depth+1)
+		}
+	}
+
+	for _, m := range roots {
+		walk(m, 0)
+	}
+
+	return entries
+}
+
+// visibleMessage looks up the message addressed by the request's username
+// and id, writing a 404 unless that user wrote a visible message with the id.
+func (a *App) visibleMessage(w http.ResponseWriter, r *http.Request) (ctrl.Message, bool) {
+	vars := mux.Vars(r)
+	id, _ := strconv.ParseUint(vars["id"], 10, 0)
+	msg, err := a.storesFor(r).Messages.GetMessage(uint(id))
+
+	if err != nil && !errors.Is(err, ctrl.ErrNotFound) {
+		logging.FromRequest(r).Error("Error in database lookup", "func", "visibleMessage", "error", err)
+		w.WriteHeader(500)
+		return msg, false
+	}
+
+	if err != nil || msg.Flagged != 0 || msg.AuthorID != a.storesFor(r).Users.GetUserID(vars["username"]) {
+		w.WriteHeader(404)
+		return msg, false
+	}
+
+	return msg, true
+}
+
+func (a *App) thread(w http.ResponseWriter, r *http.Request) {
+	session, user := a.getUserSession(w, r)
+	msg, ok := a.visibleMessage(w, r)
+
+	if !ok {
+		return
+	}
+
+	messages, err := a.storesFor(r).Messages.GetThread(msg.ID)
+
+	if err != nil {
+		logging.FromRequest(r).Error("Error in database lookup", "func", "thread", "error", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	data := struct {
+		Messages    []ThreadEntry
+		Focus       ctrl.Message
+		Username    string
+		SessionData SessionData
+	}{
+		Messages:    flattenThread(messages),
+		Focus:       msg,
+		Username:    mux.Vars(r)["username"],
+		SessionData: SessionData{User: user, Flashes: session.Flashes()},
+	}
+
+	a.templates.Render(w, r, "thread.html", data)
+}
@@ -0,0 +1,329 @@
+// Package config loads the runtime configuration shared by the MiniTwit
+// binaries. Values are read from an optional JSON file pointed to by
+// MINITWIT_CONFIG and can be overridden by environment variables.
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Duration is a time.Duration written as a string like "10s" in the config
+// file.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("durations must be strings like \"10s\": %w", err)
+	}
+
+	parsed, err := time.ParseDuration(s)
+
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(parsed)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+type DBConfig struct {
+	Driver       string `json:"driver"`   // "postgres" or "sqlite"
+	Host         string `json:"host"`     // postgres only
+	Port         int    `json:"port"`     // postgres only
+	Name         string `json:"name"`     // postgres only
+	User         string `json:"user"`     // postgres only
+	Password     string `json:"password"` // postgres only
+	SSLMode      string `json:"ssl_mode"` // postgres only
+	Path         string `json:"path"`     // sqlite only
+	MaxOpenConns int    `json:"max_open_conns"`
+	MaxIdleConns int    `json:"max_idle_conns"`
+	AutoMigrate  bool   `json:"auto_migrate"` // apply pending migrations on startup
+}
+
+type ServerConfig struct {
+	AppPort         int      `json:"app_port"`
+	APIPort         int      `json:"api_port"`
+	MetricsPort     int      `json:"metrics_port"` // Prometheus endpoint of either binary
+	ReadTimeout     Duration `json:"read_timeout"`
+	WriteTimeout    Duration `json:"write_timeout"`
+	IdleTimeout     Duration `json:"idle_timeout"`     // keep-alive connections
+	ShutdownTimeout Duration `json:"shutdown_timeout"` // time given to in-flight requests on SIGTERM
+}
+
+type TracingConfig struct {
+	Exporter    string  `json:"exporter"`     // "none", "stdout", "file" or "otlp"
+	File        string  `json:"file"`         // file only
+	Endpoint    string  `json:"endpoint"`     // otlp only, host:port of an OTLP/HTTP receiver
+	Insecure    bool    `json:"insecure"`     // otlp only, use HTTP instead of HTTPS
+	SampleRatio float64 `json:"sample_ratio"` // share of new traces that are recorded
+}
+
+type Config struct {
+	DB      DBConfig      `json:"db"`
+	Server  ServerConfig  `json:"server"`
+	Tracing TracingConfig `json:"tracing"`
+}
+
+// Default returns the configuration used by the docker-compose setup.
+func Default() *Config {
+	return &Config{
+		DB: DBConfig{
+			Driver:       "postgres",
+			Host:         "postgres",
+			Port:         5432,
+			Name:         "minitwit_db",
+			User:         "minitwit_user",
+			SSLMode:      "prefer",
+			Path:         "minitwit.db",
+			MaxOpenConns: 0, // unlimited, or 1 for sqlite
+			MaxIdleConns: 2,
+			AutoMigrate:  true,
+		},
+		Server: ServerConfig{
+			AppPort:         8080,
+			APIPort:         8000,
+			MetricsPort:     2112,
+			ReadTimeout:     Duration(10 * time.Second),
+			WriteTimeout:    Duration(10 * time.Second),
+			IdleTimeout:     Duration(60 * time.Second),
+			ShutdownTimeout: Duration(15 * time.Second),
+		},
+		Tracing: TracingConfig{
+			Exporter:    "none",
+			File:        "traces.json",
+			Endpoint:    "localhost:4318",
+			Insecure:    true,
+			SampleRatio: 1,
+		},
+	}
+}
+
+// Load builds the configuration from the defaults, the file in MINITWIT_CONFIG
+// (if set) and the environment, in that order of precedence.
+func Load() (*Config, error) {
+	cfg := Default()
+
+	if path := os.Getenv("MINITWIT_CONFIG"); path != "" {
+		if err := cfg.loadFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := cfg.loadEnv(); err != nil {
+		return nil, err
+	}
+
+	// SQLite takes one writer at a time, and a second connection would only
+	// wait on the database lock.
+	if cfg.DB.Driver == "sqlite" && cfg.DB.MaxOpenConns == 0 {
+		cfg.DB.MaxOpenConns = 1
+	}
+
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+func (cfg *Config) loadFile(path string) error {
+	f, err := os.Open(path) // #nosec G304
+	if err != nil {
+		return fmt.Errorf("config: opening %s: %w", path, err)
+	}
+	defer f.Close()
+
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(cfg); err != nil {
+		return fmt.Errorf("config: parsing %s: %w", path, err)
+	}
+
+	return nil
+}
+
+func (cfg *Config) loadEnv() error {
+	setString(&cfg.DB.Driver, "DB_DRIVER")
+	setString(&cfg.DB.Host, "DB_HOST")
+	setString(&cfg.DB.Name, "DB_NAME")
+	setString(&cfg.DB.User, "DB_USER")
+	setString(&cfg.DB.Password, "DB_PASSWD")
+	setString(&cfg.DB.SSLMode, "DB_SSLMODE")
+	setString(&cfg.DB.Path, "DB_PATH")
+
+	if err := setInt(&cfg.DB.Port, "DB_PORT"); err != nil {
+		return err
+	}
+
+	if err := setInt(&cfg.DB.MaxOpenConns, "DB_MAX_OPEN_CONNS"); err != nil {
+		return err
+	}
+
+	if err := setInt(&cfg.DB.MaxIdleConns, "DB_MAX_IDLE_CONNS"); err != nil {
+		return err
+	}
+
+	if err := setBool(&cfg.DB.AutoMigrate, "DB_AUTO_MIGRATE"); err != nil {
+		return err
+	}
+
+	if err := setInt(&cfg.Server.AppPort, "APP_PORT"); err != nil {
+		return err
+	}
+
+	if err := setInt(&cfg.Server.APIPort, "API_PORT"); err != nil {
+		return err
+	}
+
+	if err := setInt(&cfg.Server.MetricsPort, "METRICS_PORT"); err != nil {
+		return err
+	}
+
+	if err := setDuration(&cfg.Server.ReadTimeout, "HTTP_READ_TIMEOUT"); err != nil {
+		return err
+	}
+
+	if err := setDuration(&cfg.Server.WriteTimeout, "HTTP_WRITE_TIMEOUT"); err != nil {
+		return err
+	}
+
+	if err := setDuration(&cfg.Server.IdleTimeout, "HTTP_IDLE_TIMEOUT"); err != nil {
+		return err
+	}
+
+	if err := setDuration(&cfg.Server.ShutdownTimeout, "SHUTDOWN_TIMEOUT"); err != nil {
+		return err
+	}
+
+	setString(&cfg.Tracing.Exporter, "TRACING_EXPORTER")
+	setString(&cfg.Tracing.File, "TRACING_FILE")
+	setString(&cfg.Tracing.Endpoint, "TRACING_OTLP_ENDPOINT")
+
+	if err := setBool(&cfg.Tracing.Insecure, "TRACING_OTLP_INSECURE"); err != nil {
+		return err
+	}
+
+	return setFloat(&cfg.Tracing.SampleRatio, "TRACING_SAMPLE_RATIO")
+}
+
+func (cfg *Config) validate() error {
+	switch cfg.DB.Driver {
+	case "postgres":
+		if cfg.DB.Host == "" || cfg.DB.Name == "" || cfg.DB.User == "" {
+			return fmt.Errorf("config: postgres needs a host, database name and user")
+		}
+	case "sqlite":
+		if cfg.DB.Path == "" {
+			return fmt.Errorf("config: sqlite needs a database path")
+		}
+	default:
+		return fmt.Errorf("config: unsupported database driver %q", cfg.DB.Driver)
+	}
+
+	if cfg.DB.MaxOpenConns < 0 || cfg.DB.MaxIdleConns < 0 {
+		return fmt.Errorf("config: connection pool sizes cannot be negative")
+	}
+
+	for _, port := range []int{cfg.Server.AppPort, cfg.Server.APIPort, cfg.Server.MetricsPort} {
+		if port < 1 || port > 65535 {
+			return fmt.Errorf("config: port %d is out of range", port)
+		}
+	}
+
+	if cfg.Server.ReadTimeout < 0 || cfg.Server.WriteTimeout < 0 || cfg.Server.IdleTimeout < 0 || cfg.Server.ShutdownTimeout < 0 {
+		return fmt.Errorf("config: server timeouts cannot be negative")
+	}
+
+	switch cfg.Tracing.Exporter {
+	case "none", "stdout", "otlp":
+	case "file":
+		if cfg.Tracing.File == "" {
+			return fmt.Errorf("config: the file trace exporter needs a file")
+		}
+	default:
+		return fmt.Errorf("config: unsupported trace exporter %q", cfg.Tracing.Exporter)
+	}
+
+	if cfg.Tracing.SampleRatio < 0 || cfg.Tracing.SampleRatio > 1 {
+		return fmt.Errorf("config: the trace sample ratio must be between 0 and 1")
+	}
+
+	return nil
+}
+
+func setString(dst *string, key string) {
+	if val := os.Getenv(key); val != "" {
+		*dst = val
+	}
+}
+
+func setInt(dst *int, key string) error {
+	val := os.Getenv(key)
+	if val == "" {
+		return nil
+	}
+
+	n, err := strconv.Atoi(val)
+	if err != nil {
+		return fmt.Errorf("config: %s must be an integer, got %q", key, val)
+	}
+
+	*dst = n
+	return nil
+}
+
+func setBool(dst *bool, key string) error {
+	val := os.Getenv(key)
+	if val == "" {
+		return nil
+	}
+
+	b, err := strconv.ParseBool(val)
+	if err != nil {
+		return fmt.Errorf("config: %s must be a boolean, got %q", key, val)
+	}
+
+	*dst = b
+	return nil
+}
+
+func setFloat(dst *float64, key string) error {
+	val := os.Getenv(key)
+	if val == "" {
+		return nil
+	}
+
+	f, err := strconv.ParseFloat(val, 64)
+	if err != nil {
+		return fmt.Errorf("config: %s must be a number, got %q", key, val)
+	}
+
+	*dst = f
+	return nil
+}
+
+func setDuration(dst *Duration, key string) error {
+	val := os.Getenv(key)
+	if val == "" {
+		return nil
+	}
+
+	d, err := time.ParseDuration(val)
+	if err != nil {
+		return fmt.Errorf("config: %s must be a duration like 10s, got %q", key, val)
+	}
+
+	*dst = Duration(d)
+	return nil
+}
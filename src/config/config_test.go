@@ -0,0 +1,132 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// clearEnv unsets every variable Load reads, so that the environment of the
+// test run does not leak into the tests.
+func clearEnv(t *testing.T) {
+	t.Helper()
+
+	for _, key := range []string{
+		"MINITWIT_CONFIG", "DB_DRIVER", "DB_HOST", "DB_PORT", "DB_NAME", "DB_USER", "DB_PASSWD",
+		"DB_SSLMODE", "DB_PATH", "DB_MAX_OPEN_CONNS", "DB_MAX_IDLE_CONNS", "DB_AUTO_MIGRATE",
+		"APP_PORT", "API_PORT", "METRICS_PORT", "HTTP_READ_TIMEOUT", "HTTP_WRITE_TIMEOUT",
+		"HTTP_IDLE_TIMEOUT", "SHUTDOWN_TIMEOUT", "TRACING_EXPORTER", "TRACING_FILE",
+		"TRACING_OTLP_ENDPOINT", "TRACING_OTLP_INSECURE", "TRACING_SAMPLE_RATIO",
+	} {
+		t.Setenv(key, "")
+	}
+}
+
+func writeConfig(t *testing.T, content string) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.json")
+
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("MINITWIT_CONFIG", path)
+}
+
+func TestLoadDefaults(t *testing.T) {
+	clearEnv(t)
+	cfg, err := Load()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.DB.Driver != "postgres" || cfg.DB.MaxOpenConns != 0 || cfg.Server.AppPort != 8080 {
+		t.Errorf("got %+v, want the defaults", cfg)
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	clearEnv(t)
+	writeConfig(t, `{"db": {"host": "file-host", "name": "file-db"}, "server": {"app_port": 9090, "read_timeout": "3s"}}`)
+	t.Setenv("DB_HOST", "env-host")
+	t.Setenv("HTTP_WRITE_TIMEOUT", "4s")
+	cfg, err := Load()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The environment wins over the file, which wins over the defaults
+	if cfg.DB.Host != "env-host" || cfg.DB.Name != "file-db" || cfg.DB.User != "minitwit_user" {
+		t.Errorf("got host %q, name %q and user %q", cfg.DB.Host, cfg.DB.Name, cfg.DB.User)
+	}
+
+	if cfg.Server.AppPort != 9090 || cfg.Server.ReadTimeout != Duration(3*time.Second) || cfg.Server.WriteTimeout != Duration(4*time.Second) {
+		t.Errorf("got %+v", cfg.Server)
+	}
+}
+
+func TestLoadSQLitePool(t *testing.T) {
+	clearEnv(t)
+	t.Setenv("DB_DRIVER", "sqlite")
+	cfg, err := Load()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.DB.MaxOpenConns != 1 {
+		t.Errorf("sqlite pool got %d open connections, want 1", cfg.DB.MaxOpenConns)
+	}
+
+	t.Setenv("DB_MAX_OPEN_CONNS", "4")
+	cfg, err = Load()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.DB.MaxOpenConns != 4 {
+		t.Errorf("sqlite pool got %d open connections, want the configured 4", cfg.DB.MaxOpenConns)
+	}
+}
+
+func TestLoadRejectsInvalid(t *testing.T) {
+	for _, tc := range []struct {
+		env  map[string]string
+		file string
+		want string
+	}{
+		{env: map[string]string{"DB_DRIVER": "mysql"}, want: "unsupported database driver"},
+		{env: map[string]string{"DB_DRIVER": "sqlite"}, file: `{"db": {"path": ""}}`, want: "sqlite needs a database path"},
+		{env: map[string]string{"DB_PORT": "five"}, want: "DB_PORT must be an integer"},
+		{env: map[string]string{"DB_MAX_IDLE_CONNS": "-1"}, want: "cannot be negative"},
+		{env: map[string]string{"APP_PORT": "70000"}, want: "out of range"},
+		{env: map[string]string{"SHUTDOWN_TIMEOUT": "soon"}, want: "must be a duration"},
+		{env: map[string]string{"TRACING_EXPORTER": "jaeger"}, want: "unsupported trace exporter"},
+		{env: map[string]string{"TRACING_SAMPLE_RATIO": "2"}, want: "between 0 and 1"},
+		{file: `{"db": {"drivre": "sqlite"}}`, want: "unknown field"},
+	} {
+		t.Run(tc.want, func(t *testing.T) {
+			clearEnv(t)
+
+			for key, val := range tc.env {
+				t.Setenv(key, val)
+			}
+
+			if tc.file != "" {
+				writeConfig(t, tc.file)
+			}
+
+			_, err := Load()
+
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("Load() = %v, want an error containing %q", err, tc.want)
+			}
+		})
+	}
+}
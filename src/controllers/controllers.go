@@ -1,22 +1,34 @@
 package controllers
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
-	"os"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
 
 	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
 
 	"golang.org/x/crypto/bcrypt"
+
+	"minitwit/config"
 )
 
 type User struct {
-	ID       uint   `json:"id"`
-	Username string `json:"username" gorm:"not null"`
-	Email    string `json:"email" gorm:"not null"`
-	PwHash   string `json:"pw_hash" gorm:"not null"`
+	ID        uint   `json:"id"`
+	Username  string `json:"username" gorm:"not null"`
+	Email     string `json:"-" gorm:"not null"`
+	PwHash    string `json:"-" gorm:"not null"`
+	Moderator bool   `json:"moderator" gorm:"not null;default:false"`
+	Suspended bool   `json:"suspended" gorm:"not null;default:false"`
 }
 
 type Follower struct {
@@ -27,28 +39,179 @@ type Follower struct {
 }
 
 type Message struct {
-	ID       uint   `json:"message_id"`
-	AuthorID uint   `json:"author_id" gorm:"not null"`
-	Text     string `json:"text" gorm:"not null"`
-	Date     int64  `json:"pub_date"`
-	Flagged  uint8  `json:"flagged"`
-	Author   User   `gorm:"foreignKey:AuthorID"`
+	ID         uint     `json:"message_id"`
+	AuthorID   uint     `json:"author_id" gorm:"not null"`
+	Text       string   `json:"text" gorm:"not null"`
+	Date       int64    `json:"pub_date"`
+	Flagged    uint8    `json:"flagged"`
+	EditedAt   int64    `json:"edited_at"`
+	InReplyTo  uint     `json:"in_reply_to" gorm:"not null;default:0;index"` // 0 unless the message is a reply
+	ReplyCount int64    `json:"reply_count" gorm:"-"`                        // unflagged direct replies
+	Mentions   []string `json:"mentions" gorm:"-"`                           // usernames of the mentioned users
+	LikeCount  int64    `json:"like_count" gorm:"-"`
+	Author     User     `gorm:"foreignKey:AuthorID"`
+}
+
+// Mention records that a message mentions a user by @username.
+type Mention struct {
+	MessageID uint `gorm:"primaryKey;autoIncrement:false"`
+	UserID    uint `gorm:"primaryKey;autoIncrement:false;index"`
+}
+
+// Like records that a user likes a message. The key allows a user only one
+// like per message.
+type Like struct {
+	UserID    uint  `gorm:"primaryKey;autoIncrement:false"`
+	MessageID uint  `gorm:"primaryKey;autoIncrement:false;index"`
+	Date      int64 `gorm:"not null"`
+}
+
+// MessageTag records that a message contains a #tag. Tags are stored lower
+// cased.
+type MessageTag struct {
+	MessageID uint   `gorm:"primaryKey;autoIncrement:false"`
+	Tag       string `gorm:"primaryKey;index"`
+}
+
+// TagCount is a tag with the number of messages using it.
+type TagCount struct {
+	Tag   string `json:"tag"`
+	Count int64  `json:"count"`
+}
+
+// Values of Message.Flagged. Only FlagNone messages are shown in timelines.
+const (
+	FlagNone      uint8 = 0
+	FlagModerated uint8 = 1 // hidden by a moderator
+	FlagSuspended uint8 = 2 // hidden because the author is suspended
+)
+
+// Report is a user's complaint about a message. Reports are resolved when a
+// moderator flags the message or dismisses them.
+type Report struct {
+	ID         uint   `json:"id"`
+	MessageID  uint   `json:"message_id" gorm:"not null;index"`
+	ReporterID uint   `json:"reporter_id" gorm:"not null"`
+	Reason     string `json:"reason" gorm:"not null"`
+	Date       int64  `json:"date"`
+	Resolved   bool   `json:"resolved" gorm:"not null;default:false"`
+}
+
+// ReportedMessage is a message together with the reports filed against it.
+type ReportedMessage struct {
+	Message Message  `json:"message"`
+	Reports []Report `json:"reports"`
+}
+
+// MessageRevision is an earlier text of an edited message.
+type MessageRevision struct {
+	ID        uint   `json:"id"`
+	MessageID uint   `json:"message_id" gorm:"not null;index"`
+	Text      string `json:"text" gorm:"not null"`
+	Date      int64  `json:"pub_date"` // when the text was written
+}
+
+const (
+	ScopeRead     = "read"
+	ScopePost     = "post"
+	ScopeFollow   = "follow"
+	ScopeModerate = "moderate"
+)
+
+// Token is a personal API token. Only the SHA-256 hash of the token is
+// stored; the token itself is shown to the user once, when it is created.
+type Token struct {
+	ID        uint   `json:"id"`
+	UserID    uint   `json:"-" gorm:"not null;index"`
+	Name      string `json:"name" gorm:"not null"`
+	Hash      string `json:"-" gorm:"not null;uniqueIndex"`
+	Scopes    string `json:"scopes" gorm:"not null"` // space separated
+	CreatedAt int64  `json:"created_at"`
+}
+
+func (t Token) HasScope(scope string) bool {
+	for _, s := range strings.Fields(t.Scopes) {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+func ValidScope(scope string) bool {
+	return scope == ScopeRead || scope == ScopePost || scope == ScopeFollow || scope == ScopeModerate
 }
 
-func ConnectDB() *gorm.DB {
-	dsn := "host=postgres user=minitwit_user password=" + os.Getenv("DB_PASSWD") + " dbname=minitwit_db port=5432"
-	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{
+// Session is a server-side web session. The cookie only holds the session
+// key; the table stores its SHA-256 hash, so a leaked table cannot be used to
+// hijack sessions.
+type Session struct {
+	ID        uint   `json:"id"`
+	Hash      string `json:"-" gorm:"not null;uniqueIndex"`
+	UserID    uint   `json:"user_id" gorm:"not null;index"` // 0 when logged out
+	Data      string `json:"-" gorm:"not null"`
+	UserAgent string `json:"user_agent"`
+	CreatedAt int64  `json:"created_at"`
+	LastSeen  int64  `json:"last_seen"`
+	ExpiresAt int64  `json:"expires_at" gorm:"index"`
+}
+
+// Latest holds the last "latest" value reported by the simulator. The table
+// only ever has the row with ID 1.
+type Latest struct {
+	ID    uint `gorm:"primaryKey"`
+	Value int
+}
+
+func (Latest) TableName() string {
+	return "latest"
+}
+
+// postgresDSN builds a connection URL, in which the values are escaped so that
+// a password with spaces or quotes cannot change the other settings.
+func postgresDSN(cfg config.DBConfig) string {
+	dsn := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(cfg.User, cfg.Password),
+		Host:     net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port)),
+		Path:     "/" + cfg.Name,
+		RawQuery: url.Values{"sslmode": {cfg.SSLMode}}.Encode(),
+	}
+
+	return dsn.String()
+}
+
+func ConnectDB(cfg config.DBConfig) (*gorm.DB, error) {
+	var dialector gorm.Dialector
+
+	switch cfg.Driver {
+	case "postgres":
+		dialector = postgres.Open(postgresDSN(cfg))
+	case "sqlite":
+		dialector = sqlite.Open(cfg.Path)
+	default:
+		return nil, fmt.Errorf("ConnectDB: unsupported database driver %q", cfg.Driver)
+	}
+
+	db, err := gorm.Open(dialector, &gorm.Config{
 		Logger: logger.Default.LogMode(logger.Silent),
 	})
 
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "ConnectDB: Error connecting to database: %s\n", err)
-		os.Exit(1)
+		return nil, fmt.Errorf("ConnectDB: Error connecting to database: %w", err)
 	}
 
-	db.AutoMigrate(&User{}, &Follower{}, &Message{})
+	sqlDB, err := db.DB()
 
-	return db
+	if err != nil {
+		return nil, fmt.Errorf("ConnectDB: Error getting connection pool: %w", err)
+	}
+
+	sqlDB.SetMaxOpenConns(cfg.MaxOpenConns)
+	sqlDB.SetMaxIdleConns(cfg.MaxIdleConns)
+
+	return db, nil
 }
 
 func GetUserID(username string, db *gorm.DB) uint {
@@ -67,3 +230,26 @@ func HashPw(password string) (string, error) {
 	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 8)
 	return string(bytes), err
 }
+
+// The function below has been copied from: https://gowebexamples.com/password-hashing/
+func CheckPwHash(password, hash string) bool {
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	return err == nil
+}
+
+// NewToken generates a random API token and the hash to store for it.
+func NewToken() (token string, hash string, err error) {
+	bytes := make([]byte, 32)
+
+	if _, err := rand.Read(bytes); err != nil {
+		return "", "", err
+	}
+
+	token = "mt_" + base64.RawURLEncoding.EncodeToString(bytes)
+	return token, HashToken(token), nil
+}
+
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,39 @@
+package controllers
+
+import (
+	"net/url"
+	"testing"
+
+	"minitwit/config"
+)
+
+func TestPostgresDSNEscapesValues(t *testing.T) {
+	cfg := config.DBConfig{
+		Host:     "db.internal",
+		Port:     5433,
+		Name:     "minitwit db",
+		User:     "mini@twit",
+		Password: "p@ss word' sslmode=disable/?#",
+		SSLMode:  "require",
+	}
+
+	u, err := url.Parse(postgresDSN(cfg))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	password, _ := u.User.Password()
+
+	if u.User.Username() != cfg.User || password != cfg.Password {
+		t.Errorf("got user %q and password %q, want %q and %q", u.User.Username(), password, cfg.User, cfg.Password)
+	}
+
+	if u.Host != "db.internal:5433" || u.Path != "/minitwit db" {
+		t.Errorf("got host %q and path %q", u.Host, u.Path)
+	}
+
+	if q := u.Query(); len(q) != 1 || q.Get("sslmode") != "require" {
+		t.Errorf("got query %v, want only sslmode=require", q)
+	}
+}
@@ -0,0 +1,93 @@
+package controllers
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Cursor is the position of a message in a timeline ordered by date and ID.
+// Clients only see it in its opaque string form.
+type Cursor struct {
+	Date int64
+	ID   uint
+}
+
+func CursorOf(msg Message) Cursor {
+	return Cursor{Date: msg.Date, ID: msg.ID}
+}
+
+func (c Cursor) String() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d:%d", c.Date, c.ID)))
+}
+
+func ParseCursor(s string) (Cursor, error) {
+	var c Cursor
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+
+	if err != nil {
+		return c, ErrInvalidCursor
+	}
+
+	// Sscanf ignores what follows the numbers, so the cursor has to encode
+	// back to itself to be accepted.
+	if _, err := fmt.Sscanf(string(raw), "%d:%d", &c.Date, &c.ID); err != nil || c.String() != s {
+		return Cursor{}, ErrInvalidCursor
+	}
+
+	return c, nil
+}
+
+// Page selects part of a timeline. Before and After are exclusive bounds and
+// at most one of them should be set. Messages are always returned newest
+// first; with After set they are the oldest ones newer than the cursor.
+type Page struct {
+	Limit  int
+	Before *Cursor
+	After  *Cursor
+}
+
+// newer reports whether a comes before b in a timeline.
+func newer(a, b Message) bool {
+	if a.Date != b.Date {
+		return a.Date > b.Date
+	}
+	return a.ID > b.ID
+}
+
+// contains reports whether msg lies within the page's cursor bounds.
+func (p Page) contains(msg Message) bool {
+	if p.Before != nil && !newer(Message{Date: p.Before.Date, ID: p.Before.ID}, msg) {
+		return false
+	}
+	if p.After != nil && !newer(msg, Message{Date: p.After.Date, ID: p.After.ID}) {
+		return false
+	}
+	return true
+}
+
+func reverse(messages []Message) {
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+}
+
+// pageBounds returns the slice bounds of the limit items that follow the
+// first offset ones of n items.
+func pageBounds(n, limit, offset int) (int, int) {
+	if offset < 0 {
+		offset = 0
+	}
+
+	if offset > n {
+		offset = n
+	}
+
+	if limit > n-offset {
+		limit = n - offset
+	}
+
+	return offset, offset + limit
+}
@@ -0,0 +1,129 @@
+package controllers
+
+import (
+	"encoding/base64"
+	"testing"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	for _, c := range []Cursor{{}, {Date: 1700000000, ID: 42}, {Date: -1, ID: 1}} {
+		got, err := ParseCursor(c.String())
+
+		if err != nil || got != c {
+			t.Errorf("ParseCursor(%v.String()) = %v, %v", c, got, err)
+		}
+	}
+}
+
+func TestParseCursorRejectsMalformed(t *testing.T) {
+	encode := base64.RawURLEncoding.EncodeToString
+
+	for _, s := range []string{
+		"",
+		"not base64!",
+		encode([]byte("1700000000")),
+		encode([]byte("a:b")),
+		encode([]byte("1700000000:-1")),
+		encode([]byte("1700000000:42 and more")),
+	} {
+		if _, err := ParseCursor(s); err != ErrInvalidCursor {
+			t.Errorf("ParseCursor(%q) = %v, want ErrInvalidCursor", s, err)
+		}
+	}
+}
+
+// TestPagesWalkTimeline checks that paging with cursors in either direction
+// visits every message once, in a stable order even when dates are equal.
+func TestPagesWalkTimeline(t *testing.T) {
+	s := NewMemoryStore()
+	user := User{Username: "alice"}
+	s.CreateUser(&user)
+
+	var want []uint
+
+	for _, date := range []int64{30, 20, 20, 20, 20, 10, 10} {
+		msg := Message{AuthorID: user.ID, Text: "message", Date: date}
+
+		if err := s.CreateMessage(&msg); err != nil {
+			t.Fatal(err)
+		}
+
+		want = append(want, msg.ID)
+	}
+
+	// Newest first: by date, then by ID among equal dates
+	want = []uint{want[0], want[4], want[3], want[2], want[1], want[6], want[5]}
+
+	var got []uint
+	page := Page{Limit: 2}
+
+	for {
+		messages, err := s.GetPublicMessages(page)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, m := range messages {
+			got = append(got, m.ID)
+		}
+
+		if len(messages) < page.Limit {
+			break
+		}
+
+		cursor := CursorOf(messages[len(messages)-1])
+		page.Before = &cursor
+	}
+
+	if !equalIDs(got, want) {
+		t.Fatalf("walking back got %v, want %v", got, want)
+	}
+
+	// Walking forward from the oldest message returns the pages newest first,
+	// so they are prepended.
+	got = nil
+	oldest := Cursor{Date: 10, ID: want[len(want)-1]}
+	page = Page{Limit: 2, After: &oldest}
+
+	for {
+		messages, err := s.GetPublicMessages(page)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ids := make([]uint, len(messages))
+
+		for i, m := range messages {
+			ids[i] = m.ID
+		}
+
+		got = append(ids, got...)
+
+		if len(messages) < page.Limit {
+			break
+		}
+
+		cursor := CursorOf(messages[0])
+		page.After = &cursor
+	}
+
+	if !equalIDs(got, want[:len(want)-1]) {
+		t.Errorf("walking forward got %v, want %v", got, want[:len(want)-1])
+	}
+}
+
+func equalIDs(a, b []uint) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
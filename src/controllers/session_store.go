@@ -0,0 +1,220 @@
+package controllers
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+)
+
+// touchInterval limits how often a session's last seen time is written back,
+// so that browsing does not turn every request into a database write.
+const touchInterval = 60
+
+// touchedKey marks in the session values that loading the session extended its
+// expiry. It never reaches the database.
+type touchedKey struct{}
+
+// ServerSessionStore is a gorilla sessions.Store that keeps the session values
+// in a SessionStore. The cookie only carries the signed session key, so a
+// session can be revoked by deleting its record.
+type ServerSessionStore struct {
+	Codecs  []securecookie.Codec
+	Options *sessions.Options // default configuration
+	store   SessionStore
+	// dataCodecs encode the stored values. They have no maximum age, since
+	// the expiry of the record decides how long a session lives.
+	dataCodecs []securecookie.Codec
+}
+
+// NewServerSessionStore returns a ServerSessionStore backed by store. See
+// sessions.NewCookieStore for a description of keyPairs.
+func NewServerSessionStore(store SessionStore, keyPairs ...[]byte) *ServerSessionStore {
+	s := &ServerSessionStore{
+		Codecs: securecookie.CodecsFromPairs(keyPairs...),
+		Options: &sessions.Options{
+			Path:     "/",
+			MaxAge:   86400 * 30,
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		},
+		store:      store,
+		dataCodecs: securecookie.CodecsFromPairs(keyPairs...),
+	}
+
+	for _, codec := range s.Codecs {
+		if sc, ok := codec.(*securecookie.SecureCookie); ok {
+			sc.MaxAge(s.Options.MaxAge)
+		}
+	}
+
+	for _, codec := range s.dataCodecs {
+		if sc, ok := codec.(*securecookie.SecureCookie); ok {
+			sc.MaxAge(0)
+		}
+	}
+
+	return s
+}
+
+// Get returns the session for the given name after adding it to the registry.
+func (s *ServerSessionStore) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(s, name)
+}
+
+// New loads the session named by the request's cookie. Unknown, expired and
+// revoked sessions yield a new, empty session.
+func (s *ServerSessionStore) New(r *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(s, name)
+	opts := *s.Options
+	session.Options = &opts
+	session.IsNew = true
+
+	cookie, err := r.Cookie(name)
+
+	if err != nil {
+		return session, nil
+	}
+
+	var id string
+
+	if err := securecookie.DecodeMulti(name, cookie.Value, &id, s.Codecs...); err != nil {
+		return session, err
+	}
+
+	now := time.Now().Unix()
+	record, err := s.store.GetSession(HashToken(id), now)
+
+	if errors.Is(err, ErrNotFound) {
+		return session, nil
+	} else if err != nil {
+		return session, err
+	}
+
+	if err := securecookie.DecodeMulti(name, record.Data, &session.Values, s.dataCodecs...); err != nil {
+		return session, err
+	}
+
+	session.ID = id
+	session.IsNew = false
+
+	if now-record.LastSeen >= touchInterval {
+		err = s.store.TouchSession(record.Hash, now, now+int64(session.Options.MaxAge))
+		session.Values[touchedKey{}] = true
+	}
+
+	return session, err
+}
+
+// Save persists the session and sets its cookie. Sessions with MaxAge <= 0 or
+// without values are deleted instead. A session that was deleted since it was
+// loaded, for example because it was revoked from another device, is not
+// saved again; its cookie is expired and its values are cleared.
+func (s *ServerSessionStore) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	delete(session.Values, touchedKey{})
+
+	if session.Options.MaxAge <= 0 || len(session.Values) == 0 {
+		if session.ID != "" {
+			if err := s.store.DeleteSession(HashToken(session.ID)); err != nil {
+				return err
+			}
+		}
+
+		if !session.IsNew || session.Options.MaxAge <= 0 {
+			expireCookie(w, session)
+		}
+
+		return nil
+	}
+
+	data, err := securecookie.EncodeMulti(session.Name(), session.Values, s.dataCodecs...)
+
+	if err != nil {
+		return err
+	}
+
+	userID, _ := session.Values["user_id"].(uint)
+	now := time.Now().Unix()
+	record := &Session{
+		UserID:    userID,
+		Data:      data,
+		UserAgent: r.UserAgent(),
+		CreatedAt: now,
+		LastSeen:  now,
+		ExpiresAt: now + int64(session.Options.MaxAge),
+	}
+
+	if session.ID == "" {
+		session.ID = base64.RawURLEncoding.EncodeToString(securecookie.GenerateRandomKey(32))
+		record.Hash = HashToken(session.ID)
+		err = s.store.CreateSession(record)
+	} else {
+		record.Hash = HashToken(session.ID)
+		err = s.store.UpdateSession(record)
+	}
+
+	if errors.Is(err, ErrNotFound) {
+		session.ID = ""
+		session.Values = make(map[interface{}]interface{})
+		expireCookie(w, session)
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	return s.setCookie(w, session)
+}
+
+// Refresh sets the cookie of session again if loading it extended its expiry,
+// so that the browser keeps the cookie as long as the session is kept.
+func (s *ServerSessionStore) Refresh(w http.ResponseWriter, session *sessions.Session) error {
+	if _, ok := session.Values[touchedKey{}]; !ok {
+		return nil
+	}
+
+	delete(session.Values, touchedKey{})
+	return s.setCookie(w, session)
+}
+
+func (s *ServerSessionStore) setCookie(w http.ResponseWriter, session *sessions.Session) error {
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, s.Codecs...)
+
+	if err != nil {
+		return err
+	}
+
+	http.SetCookie(w, sessions.NewCookie(session.Name(), encoded, session.Options))
+	return nil
+}
+
+func expireCookie(w http.ResponseWriter, session *sessions.Session) {
+	opts := *session.Options
+	opts.MaxAge = -1
+	http.SetCookie(w, sessions.NewCookie(session.Name(), "", &opts))
+}
+
+// Renew deletes the stored session and gives it a fresh key on its next save,
+// keeping its values. Call it when the user signs in or out so that a key
+// known before the change is of no use afterwards.
+func (s *ServerSessionStore) Renew(session *sessions.Session) error {
+	if session.ID == "" {
+		return nil
+	}
+
+	err := s.store.DeleteSession(HashToken(session.ID))
+	session.ID = ""
+	return err
+}
+
+// SessionHash returns the hash under which session is stored, or "" if it has
+// not been saved yet.
+func SessionHash(session *sessions.Session) string {
+	if session.ID == "" {
+		return ""
+	}
+
+	return HashToken(session.ID)
+}
@@ -0,0 +1,75 @@
+package controllers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/securecookie"
+)
+
+// encodeAt encodes value the way a securecookie codec with only a hash key
+// does, but stamped with the time at, so that it stands in for data saved
+// long ago.
+func encodeAt(t *testing.T, name string, value interface{}, hashKey []byte, at time.Time) string {
+	t.Helper()
+
+	b, err := securecookie.GobEncoder{}.Serialize(value)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b = []byte(fmt.Sprintf("%s|%d|%s|", name, at.Unix(), base64.URLEncoding.EncodeToString(b)))
+	mac := hmac.New(sha256.New, hashKey)
+	mac.Write(b[:len(b)-1])
+	b = append(b, mac.Sum(nil)...)[len(name)+1:]
+	return base64.URLEncoding.EncodeToString(b)
+}
+
+func TestSessionOutlivesItsLastSave(t *testing.T) {
+	key := []byte("test-session-key")
+	sessions := NewMemoryStores().Sessions
+	store := NewServerSessionStore(sessions, key)
+
+	// Saved 40 days ago and kept alive by reading pages ever since
+	now := time.Now()
+	saved := now.Add(-40 * 24 * time.Hour)
+	id := "session-key"
+
+	err := sessions.CreateSession(&Session{
+		Hash:      HashToken(id),
+		UserID:    1,
+		Data:      encodeAt(t, "user-session", map[interface{}]interface{}{"user_id": uint(1)}, key, saved),
+		CreatedAt: saved.Unix(),
+		LastSeen:  now.Unix(),
+		ExpiresAt: now.Add(29 * 24 * time.Hour).Unix(),
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cookie, err := securecookie.EncodeMulti("user-session", id, store.Codecs...)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(&http.Cookie{Name: "user-session", Value: cookie})
+	session, err := store.New(r, "user-session")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if session.IsNew || session.Values["user_id"] != uint(1) {
+		t.Errorf("got a new session with %v, want the stored one", session.Values)
+	}
+}
@@ -0,0 +1,181 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrNotFound is returned by the stores when a looked up record does not exist.
+var ErrNotFound = errors.New("record not found")
+
+type UserStore interface {
+	// GetUserID returns 0 if no user has the given username.
+	GetUserID(username string) uint
+	GetUserByID(id uint) (User, error)
+	GetUserByUsername(username string) (User, error)
+	CreateUser(user *User) error
+}
+
+type FollowStore interface {
+	Follow(followerID, followsID uint) error
+	Unfollow(followerID, followsID uint) error
+	IsFollowing(followerID, followsID uint) (bool, error)
+	// GetFollowers returns the users following userID.
+	GetFollowers(userID uint) ([]User, error)
+}
+
+// The messages returned by a MessageStore have their Author, ReplyCount,
+// Mentions and LikeCount filled in.
+type MessageStore interface {
+	// CreateMessage stores a message along with the users it mentions and its
+	// tags.
+	CreateMessage(msg *Message) error
+	GetMessage(id uint) (Message, error)
+	// UpdateMessage replaces the text of a message and keeps the old text
+	// as a revision. The mentions and tags are updated to match the new text.
+	UpdateMessage(id uint, text string, editedAt int64) error
+	// DeleteMessage deletes a message along with its revisions, mentions,
+	// tags and likes.
+	DeleteMessage(id uint) error
+	// GetRevisions returns the earlier texts of a message, oldest first.
+	GetRevisions(messageID uint) ([]MessageRevision, error)
+	// GetPublicMessages returns the latest unflagged messages of all users.
+	GetPublicMessages(page Page) ([]Message, error)
+	// GetUserMessages returns the latest unflagged messages written by userID.
+	GetUserMessages(userID uint, page Page) ([]Message, error)
+	// GetTimelineMessages returns the latest unflagged messages written by
+	// userID or by the users that userID follows.
+	GetTimelineMessages(userID uint, page Page) ([]Message, error)
+	// GetThread returns the unflagged messages of the conversation that id
+	// belongs to, from its first message down to all replies, oldest first.
+	GetThread(id uint) ([]Message, error)
+	// GetMentionMessages returns the latest unflagged messages that mention
+	// userID.
+	GetMentionMessages(userID uint, page Page) ([]Message, error)
+	// GetTagMessages returns the latest unflagged messages with the lower
+	// cased tag.
+	GetTagMessages(tag string, page Page) ([]Message, error)
+	// GetLikedMessages returns the latest unflagged messages that userID
+	// likes.
+	GetLikedMessages(userID uint, page Page) ([]Message, error)
+	// GetTrendingTags returns the limit tags used by the most unflagged
+	// messages posted since the given time, most used first.
+	GetTrendingTags(since int64, limit int) ([]TagCount, error)
+}
+
+type LikeStore interface {
+	// Like records that userID likes a message. Liking a message twice
+	// changes nothing, even when both requests race.
+	Like(userID, messageID uint, date int64) error
+	// Unlike removes the like of userID, if there is one.
+	Unlike(userID, messageID uint) error
+	// GetLikers returns the users who like a message, latest like first.
+	GetLikers(messageID uint) ([]User, error)
+	// GetLikedIDs reports which of messageIDs userID likes.
+	GetLikedIDs(userID uint, messageIDs []uint) (map[uint]bool, error)
+}
+
+type LatestStore interface {
+	GetLatest() (int, error)
+	// UpdateLatest stores val unless a larger value is stored already.
+	UpdateLatest(val int) error
+}
+
+type TokenStore interface {
+	CreateToken(token *Token) error
+	GetTokenByHash(hash string) (Token, error)
+	GetTokens(userID uint) ([]Token, error)
+	// DeleteToken returns ErrNotFound unless userID owns the token.
+	DeleteToken(userID, tokenID uint) error
+}
+
+type ModerationStore interface {
+	ReportMessage(report *Report) error
+	// GetOpenReports returns the messages that have unresolved reports.
+	GetOpenReports() ([]ReportedMessage, error)
+	// GetFlaggedMessages returns the messages hidden by moderators, with all
+	// reports filed against them.
+	GetFlaggedMessages() ([]ReportedMessage, error)
+	// FlagMessage hides a message and resolves its reports. The moderator's
+	// reason is kept as a resolved report of its own.
+	FlagMessage(id, moderatorID uint, reason string, date int64) error
+	// UnflagMessage undoes FlagMessage. The message stays hidden with
+	// FlagSuspended while its author is suspended.
+	UnflagMessage(id uint) error
+	// DismissReports resolves the reports of a message without hiding it. It
+	// returns ErrNotFound if the message has no open reports.
+	DismissReports(messageID uint) error
+	// SetSuspended suspends or reinstates a user. The messages of a suspended
+	// user are flagged with FlagSuspended, so that they are hidden as well.
+	SetSuspended(userID uint, suspended bool) error
+	SetModerator(userID uint, moderator bool) error
+}
+
+type SessionStore interface {
+	// GetSession returns ErrNotFound for unknown and expired sessions.
+	GetSession(hash string, now int64) (Session, error)
+	CreateSession(session *Session) error
+	// UpdateSession updates everything but the creation time of the session
+	// with the same hash. It returns ErrNotFound if the session was deleted,
+	// so that a revoked session is not brought back.
+	UpdateSession(session *Session) error
+	TouchSession(hash string, lastSeen, expiresAt int64) error
+	DeleteSession(hash string) error
+	DeleteExpiredSessions(now int64) error
+	GetUserSessions(userID uint, now int64) ([]Session, error)
+	// DeleteUserSession returns ErrNotFound unless userID owns the session.
+	DeleteUserSession(userID, id uint) error
+	// DeleteUserSessions deletes all sessions of userID except the one with
+	// keepHash.
+	DeleteUserSessions(userID uint, keepHash string) error
+}
+
+// Totals are the sizes of the main tables. ActiveUsers counts the users who
+// posted since a given time. Flagged messages are not counted.
+type Totals struct {
+	Users       int64
+	Messages    int64
+	Follows     int64
+	ActiveUsers int64
+}
+
+type StatsStore interface {
+	GetTotals(activeSince int64) (Totals, error)
+}
+
+// SearchStore finds messages and users by the words of a search query, most
+// relevant first. The results of a query with no words are empty.
+type SearchStore interface {
+	// SearchMessages returns the unflagged messages containing the words.
+	SearchMessages(query string, limit, offset int) ([]Message, error)
+	// SearchUsers returns the users who are not suspended and whose
+	// usernames match all words of the query, which may be the start of
+	// longer words.
+	SearchUsers(query string, limit, offset int) ([]User, error)
+}
+
+// Stores bundles the stores used by the MiniTwit handlers.
+type Stores struct {
+	Users      UserStore
+	Follows    FollowStore
+	Messages   MessageStore
+	Likes      LikeStore
+	Latest     LatestStore
+	Tokens     TokenStore
+	Moderation ModerationStore
+	Sessions   SessionStore
+	Stats      StatsStore
+	Search     SearchStore
+
+	withContext func(context.Context) Stores
+}
+
+// WithContext returns the stores with their queries bound to ctx, so that
+// they are cancelled and traced along with the request.
+func (s Stores) WithContext(ctx context.Context) Stores {
+	if s.withContext == nil {
+		return s
+	}
+
+	return s.withContext(ctx)
+}
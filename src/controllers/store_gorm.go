@@ -0,0 +1,1001 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// GormStore implements the stores on top of a GORM database.
+type GormStore struct {
+	db *gorm.DB
+	// fts holds the tables with a usable FTS5 table. It is looked up once and
+	// shared with the stores bound to request contexts.
+	fts map[string]bool
+}
+
+func NewGormStore(db *gorm.DB) *GormStore {
+	return &GormStore{db: db, fts: ftsTables(db)}
+}
+
+func NewGormStores(db *gorm.DB) Stores {
+	return newGormStores(NewGormStore(db))
+}
+
+func newGormStores(s *GormStore) Stores {
+	return Stores{
+		Users:      s,
+		Follows:    s,
+		Messages:   s,
+		Likes:      s,
+		Latest:     s,
+		Tokens:     s,
+		Moderation: s,
+		Sessions:   s,
+		Stats:      s,
+		Search:     s,
+		withContext: func(ctx context.Context) Stores {
+			return newGormStores(&GormStore{db: s.db.WithContext(ctx), fts: s.fts})
+		},
+	}
+}
+
+func notFound(err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrNotFound
+	}
+
+	return err
+}
+
+func (s *GormStore) GetUserID(username string) uint {
+	return GetUserID(username, s.db)
+}
+
+func (s *GormStore) GetUserByID(id uint) (User, error) {
+	var user User
+	err := s.db.First(&user, "id = ?", id).Error
+	return user, notFound(err)
+}
+
+func (s *GormStore) GetUserByUsername(username string) (User, error) {
+	var user User
+	err := s.db.First(&user, "username = ?", username).Error
+	return user, notFound(err)
+}
+
+func (s *GormStore) CreateUser(user *User) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(user).Error; err != nil {
+			return err
+		}
+
+		return s.indexFTS(tx, "users", user.ID)
+	})
+}
+
+func (s *GormStore) Follow(followerID, followsID uint) error {
+	return s.db.FirstOrCreate(&Follower{}, &Follower{
+		FollowerID: followerID,
+		FollowsID:  followsID,
+	}).Error
+}
+
+func (s *GormStore) Unfollow(followerID, followsID uint) error {
+	return s.db.Where("follower_id = ? AND follows_id = ?", followerID, followsID).
+		Delete(&Follower{}).Error
+}
+
+func (s *GormStore) IsFollowing(followerID, followsID uint) (bool, error) {
+	var count int64
+	err := s.db.Model(&Follower{}).
+		Where("follower_id = ? AND follows_id = ?", followerID, followsID).
+		Count(&count).Error
+	return count > 0, err
+}
+
+func (s *GormStore) GetFollowers(userID uint) ([]User, error) {
+	var followers []User
+	err := s.db.Select("users.username").
+		Joins("INNER JOIN followers ON users.id = followers.follower_id").
+		Find(&followers, "followers.follows_id = ?", userID).Error
+	return followers, err
+}
+
+func (s *GormStore) CreateMessage(msg *Message) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(msg).Error; err != nil {
+			return err
+		}
+
+		if err := indexMentions(tx, msg.ID, msg.Text); err != nil {
+			return err
+		}
+
+		if err := indexTags(tx, msg.ID, msg.Text); err != nil {
+			return err
+		}
+
+		return s.indexFTS(tx, "messages", msg.ID)
+	})
+}
+
+// indexMentions records the users mentioned in the text of a message. Names
+// that do not belong to a user are ignored.
+func indexMentions(tx *gorm.DB, messageID uint, text string) error {
+	var mentions []Mention
+
+	for _, name := range ParseMentions(text) {
+		if userID := GetUserID(name, tx); userID != 0 {
+			mentions = append(mentions, Mention{MessageID: messageID, UserID: userID})
+		}
+	}
+
+	if len(mentions) == 0 {
+		return nil
+	}
+
+	return tx.Create(&mentions).Error
+}
+
+// indexTags records the tags in the text of a message.
+func indexTags(tx *gorm.DB, messageID uint, text string) error {
+	var tags []MessageTag
+
+	for _, tag := range ParseTags(text) {
+		tags = append(tags, MessageTag{MessageID: messageID, Tag: tag})
+	}
+
+	if len(tags) == 0 {
+		return nil
+	}
+
+	return tx.Create(&tags).Error
+}
+
+func (s *GormStore) GetMessage(id uint) (Message, error) {
+	var msg Message
+
+	if err := s.db.Joins("Author").First(&msg, "messages.id = ?", id).Error; err != nil {
+		return msg, notFound(err)
+	}
+
+	messages := []Message{msg}
+	err := s.annotate(messages)
+	return messages[0], err
+}
+
+// annotate fills in the fields of messages that are not columns of the
+// messages table, with one query per field.
+func (s *GormStore) annotate(messages []Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	ids := make([]uint, len(messages))
+
+	for i, m := range messages {
+		ids[i] = m.ID
+	}
+
+	var replies []struct {
+		InReplyTo uint
+		Count     int64
+	}
+
+	err := s.db.Model(&Message{}).
+		Select("in_reply_to, COUNT(*) AS count").
+		Where("in_reply_to IN ? AND flagged = ?", ids, FlagNone).
+		Group("in_reply_to").
+		Scan(&replies).Error
+
+	if err != nil {
+		return err
+	}
+
+	var mentions []struct {
+		MessageID uint
+		Username  string
+	}
+
+	err = s.db.Model(&Mention{}).
+		Select("mentions.message_id, users.username").
+		Joins("JOIN users ON users.id = mentions.user_id").
+		Where("mentions.message_id IN ?", ids).
+		Scan(&mentions).Error
+
+	if err != nil {
+		return err
+	}
+
+	var likes []struct {
+		MessageID uint
+		Count     int64
+	}
+
+	err = s.db.Model(&Like{}).
+		Select("message_id, COUNT(*) AS count").
+		Where("message_id IN ?", ids).
+		Group("message_id").
+		Scan(&likes).Error
+
+	if err != nil {
+		return err
+	}
+
+	counts := make(map[uint]int64, len(replies))
+	names := make(map[uint][]string)
+	likeCounts := make(map[uint]int64, len(likes))
+
+	for _, row := range replies {
+		counts[row.InReplyTo] = row.Count
+	}
+
+	for _, row := range mentions {
+		names[row.MessageID] = append(names[row.MessageID], row.Username)
+	}
+
+	for _, row := range likes {
+		likeCounts[row.MessageID] = row.Count
+	}
+
+	for i := range messages {
+		messages[i].ReplyCount = counts[messages[i].ID]
+		messages[i].Mentions = append([]string{}, names[messages[i].ID]...)
+		messages[i].LikeCount = likeCounts[messages[i].ID]
+	}
+
+	return nil
+}
+
+func (s *GormStore) UpdateMessage(id uint, text string, editedAt int64) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		var msg Message
+
+		// Lock the row so that concurrent edits each keep their own revision
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&msg, "id = ?", id).Error
+
+		if err != nil {
+			return notFound(err)
+		}
+
+		revisionDate := msg.Date
+
+		if msg.EditedAt != 0 {
+			revisionDate = msg.EditedAt
+		}
+
+		err = tx.Create(&MessageRevision{
+			MessageID: msg.ID,
+			Text:      msg.Text,
+			Date:      revisionDate,
+		}).Error
+
+		if err != nil {
+			return err
+		}
+
+		err = tx.Model(&msg).Updates(map[string]interface{}{
+			"text":      text,
+			"edited_at": editedAt,
+		}).Error
+
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Where("message_id = ?", msg.ID).Delete(&Mention{}).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Where("message_id = ?", msg.ID).Delete(&MessageTag{}).Error; err != nil {
+			return err
+		}
+
+		if err := indexMentions(tx, msg.ID, text); err != nil {
+			return err
+		}
+
+		if err := indexTags(tx, msg.ID, text); err != nil {
+			return err
+		}
+
+		if err := s.unindexFTS(tx, "messages", msg.ID); err != nil {
+			return err
+		}
+
+		return s.indexFTS(tx, "messages", msg.ID)
+	})
+}
+
+func (s *GormStore) DeleteMessage(id uint) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("message_id = ?", id).Delete(&MessageRevision{}).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Where("message_id = ?", id).Delete(&Mention{}).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Where("message_id = ?", id).Delete(&MessageTag{}).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Where("message_id = ?", id).Delete(&Like{}).Error; err != nil {
+			return err
+		}
+
+		if err := s.unindexFTS(tx, "messages", id); err != nil {
+			return err
+		}
+
+		query := tx.Where("id = ?", id).Delete(&Message{})
+
+		if query.Error == nil && query.RowsAffected == 0 {
+			return ErrNotFound
+		}
+
+		return query.Error
+	})
+}
+
+func (s *GormStore) GetRevisions(messageID uint) ([]MessageRevision, error) {
+	var revisions []MessageRevision
+	err := s.db.Order("id").Find(&revisions, "message_id = ?", messageID).Error
+	return revisions, err
+}
+
+// messages returns the unflagged messages on the given page, newest first.
+// scope narrows the query down to a single timeline.
+func (s *GormStore) messages(page Page, scope func(*gorm.DB) *gorm.DB) ([]Message, error) {
+	var messages []Message
+
+	// Joining the author loads it into each message in the same query, so
+	// rendering a timeline does not look up users one message at a time.
+	query := scope(s.db.Limit(page.Limit).
+		Joins("Author").
+		Where("messages.flagged = ?", 0))
+
+	if page.After != nil {
+		query = query.Order("messages.date asc, messages.id asc").
+			Where("messages.date > ? OR (messages.date = ? AND messages.id > ?)",
+				page.After.Date, page.After.Date, page.After.ID)
+	} else {
+		query = query.Order("messages.date desc, messages.id desc")
+
+		if page.Before != nil {
+			query = query.Where("messages.date < ? OR (messages.date = ? AND messages.id < ?)",
+				page.Before.Date, page.Before.Date, page.Before.ID)
+		}
+	}
+
+	if err := query.Find(&messages).Error; err != nil {
+		return nil, err
+	}
+
+	if page.After != nil {
+		reverse(messages)
+	}
+
+	return messages, s.annotate(messages)
+}
+
+func (s *GormStore) GetPublicMessages(page Page) ([]Message, error) {
+	return s.messages(page, func(q *gorm.DB) *gorm.DB { return q })
+}
+
+func (s *GormStore) GetUserMessages(userID uint, page Page) ([]Message, error) {
+	return s.messages(page, func(q *gorm.DB) *gorm.DB {
+		return q.Where("messages.author_id = ?", userID)
+	})
+}
+
+func (s *GormStore) GetTimelineMessages(userID uint, page Page) ([]Message, error) {
+	subquery := s.db.Model(&Follower{}).Select("follows_id").Where("follower_id = ?", userID)
+
+	return s.messages(page, func(q *gorm.DB) *gorm.DB {
+		return q.Where(s.db.Where("messages.author_id = ?", userID).Or("messages.author_id IN (?)", subquery))
+	})
+}
+
+func (s *GormStore) GetMentionMessages(userID uint, page Page) ([]Message, error) {
+	subquery := s.db.Model(&Mention{}).Select("message_id").Where("user_id = ?", userID)
+
+	return s.messages(page, func(q *gorm.DB) *gorm.DB {
+		return q.Where("messages.id IN (?)", subquery)
+	})
+}
+
+func (s *GormStore) GetTagMessages(tag string, page Page) ([]Message, error) {
+	subquery := s.db.Model(&MessageTag{}).Select("message_id").Where("tag = ?", tag)
+
+	return s.messages(page, func(q *gorm.DB) *gorm.DB {
+		return q.Where("messages.id IN (?)", subquery)
+	})
+}
+
+func (s *GormStore) GetLikedMessages(userID uint, page Page) ([]Message, error) {
+	subquery := s.db.Model(&Like{}).Select("message_id").Where("user_id = ?", userID)
+
+	return s.messages(page, func(q *gorm.DB) *gorm.DB {
+		return q.Where("messages.id IN (?)", subquery)
+	})
+}
+
+func (s *GormStore) GetTrendingTags(since int64, limit int) ([]TagCount, error) {
+	var tags []TagCount
+	err := s.db.Model(&MessageTag{}).
+		Select("message_tags.tag, COUNT(*) AS count").
+		Joins("JOIN messages ON messages.id = message_tags.message_id").
+		Where("messages.date >= ? AND messages.flagged = ?", since, FlagNone).
+		Group("message_tags.tag").
+		Order("count desc, message_tags.tag").
+		Limit(limit).
+		Scan(&tags).Error
+	return tags, err
+}
+
+// threadQuery walks up from a message to the first message of its
+// conversation, which either is no reply or answers a deleted message, and
+// then down from there to all replies.
+const threadQuery = `
+WITH RECURSIVE ancestors(id, in_reply_to) AS (
+	SELECT id, in_reply_to FROM messages WHERE id = ?
+	UNION ALL
+	SELECT m.id, m.in_reply_to FROM messages m JOIN ancestors a ON m.id = a.in_reply_to
+), thread(id) AS (
+	SELECT a.id FROM ancestors a
+	WHERE NOT EXISTS (SELECT 1 FROM ancestors p WHERE p.id = a.in_reply_to)
+	UNION ALL
+	SELECT m.id FROM messages m JOIN thread t ON m.in_reply_to = t.id
+)
+SELECT id FROM thread`
+
+func (s *GormStore) GetThread(id uint) ([]Message, error) {
+	var ids []uint
+
+	if err := s.db.Raw(threadQuery, id).Scan(&ids).Error; err != nil {
+		return nil, err
+	}
+
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	var messages []Message
+	err := s.db.Joins("Author").
+		Where("messages.id IN ? AND messages.flagged = ?", ids, FlagNone).
+		Order("messages.date asc, messages.id asc").
+		Find(&messages).Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	return messages, s.annotate(messages)
+}
+
+// Like relies on the primary key of the likes table rather than checking for
+// an existing like first, so that concurrent likes cannot both insert a row.
+func (s *GormStore) Like(userID, messageID uint, date int64) error {
+	return s.db.Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&Like{UserID: userID, MessageID: messageID, Date: date}).Error
+}
+
+func (s *GormStore) Unlike(userID, messageID uint) error {
+	return s.db.Where("user_id = ? AND message_id = ?", userID, messageID).Delete(&Like{}).Error
+}
+
+func (s *GormStore) GetLikers(messageID uint) ([]User, error) {
+	var users []User
+	err := s.db.Joins("JOIN likes ON likes.user_id = users.id").
+		Where("likes.message_id = ?", messageID).
+		Order("likes.date desc, users.id").
+		Find(&users).Error
+	return users, err
+}
+
+func (s *GormStore) GetLikedIDs(userID uint, messageIDs []uint) (map[uint]bool, error) {
+	liked := make(map[uint]bool)
+
+	if len(messageIDs) == 0 {
+		return liked, nil
+	}
+
+	var ids []uint
+	err := s.db.Model(&Like{}).
+		Where("user_id = ? AND message_id IN ?", userID, messageIDs).
+		Pluck("message_id", &ids).Error
+
+	for _, id := range ids {
+		liked[id] = true
+	}
+
+	return liked, err
+}
+
+func (s *GormStore) GetLatest() (int, error) {
+	var latest Latest
+	err := s.db.First(&latest, 1).Error
+	return latest.Value, err
+}
+
+func (s *GormStore) UpdateLatest(val int) error {
+	// A single conditional UPDATE keeps concurrent requests and replicas from
+	// moving the value backwards.
+	return s.db.Model(&Latest{}).
+		Where("id = ? AND value < ?", 1, val).
+		Update("value", val).Error
+}
+
+func (s *GormStore) CreateToken(token *Token) error {
+	return s.db.Create(token).Error
+}
+
+func (s *GormStore) GetTokenByHash(hash string) (Token, error) {
+	var token Token
+	err := s.db.First(&token, "hash = ?", hash).Error
+	return token, notFound(err)
+}
+
+func (s *GormStore) GetTokens(userID uint) ([]Token, error) {
+	var tokens []Token
+	err := s.db.Order("id").Find(&tokens, "user_id = ?", userID).Error
+	return tokens, err
+}
+
+func (s *GormStore) DeleteToken(userID, tokenID uint) error {
+	query := s.db.Where("id = ? AND user_id = ?", tokenID, userID).Delete(&Token{})
+
+	if query.Error == nil && query.RowsAffected == 0 {
+		return ErrNotFound
+	}
+
+	return query.Error
+}
+
+func (s *GormStore) ReportMessage(report *Report) error {
+	return s.db.Create(report).Error
+}
+
+// reportedMessages loads the given messages with their authors, and attaches
+// the reports matching the reports query to them.
+func (s *GormStore) reportedMessages(ids []uint, reports *gorm.DB) ([]ReportedMessage, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	var messages []Message
+	var rows []Report
+
+	if err := s.db.Preload("Author").Order("id").Find(&messages, ids).Error; err != nil {
+		return nil, err
+	}
+
+	if err := reports.Where("message_id IN ?", ids).Order("id").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	byMessage := make(map[uint][]Report)
+
+	for _, r := range rows {
+		byMessage[r.MessageID] = append(byMessage[r.MessageID], r)
+	}
+
+	reported := make([]ReportedMessage, 0, len(messages))
+
+	for _, m := range messages {
+		reported = append(reported, ReportedMessage{Message: m, Reports: byMessage[m.ID]})
+	}
+
+	return reported, nil
+}
+
+func (s *GormStore) GetOpenReports() ([]ReportedMessage, error) {
+	var ids []uint
+	err := s.db.Model(&Report{}).Distinct("message_id").
+		Where("resolved = ?", false).Pluck("message_id", &ids).Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	return s.reportedMessages(ids, s.db.Where("resolved = ?", false))
+}
+
+func (s *GormStore) GetFlaggedMessages() ([]ReportedMessage, error) {
+	var ids []uint
+	err := s.db.Model(&Message{}).Where("flagged = ?", FlagModerated).Pluck("id", &ids).Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	return s.reportedMessages(ids, s.db)
+}
+
+func (s *GormStore) FlagMessage(id, moderatorID uint, reason string, date int64) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		query := tx.Model(&Message{}).Where("id = ?", id).Update("flagged", FlagModerated)
+
+		if query.Error != nil {
+			return query.Error
+		} else if query.RowsAffected == 0 {
+			return ErrNotFound
+		}
+
+		err := tx.Model(&Report{}).Where("message_id = ?", id).Update("resolved", true).Error
+
+		if err != nil {
+			return err
+		}
+
+		return tx.Create(&Report{
+			MessageID:  id,
+			ReporterID: moderatorID,
+			Reason:     reason,
+			Date:       date,
+			Resolved:   true,
+		}).Error
+	})
+}
+
+func (s *GormStore) UnflagMessage(id uint) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		suspended := tx.Model(&User{}).Select("id").Where("suspended = ?", true)
+
+		// Messages of suspended authors go back to being hidden with them
+		query := tx.Model(&Message{}).
+			Where("id = ? AND flagged = ? AND author_id IN (?)", id, FlagModerated, suspended).
+			Update("flagged", FlagSuspended)
+
+		if query.Error != nil || query.RowsAffected > 0 {
+			return query.Error
+		}
+
+		query = tx.Model(&Message{}).
+			Where("id = ? AND flagged = ?", id, FlagModerated).
+			Update("flagged", FlagNone)
+
+		if query.Error == nil && query.RowsAffected == 0 {
+			return ErrNotFound
+		}
+
+		return query.Error
+	})
+}
+
+func (s *GormStore) DismissReports(messageID uint) error {
+	query := s.db.Model(&Report{}).
+		Where("message_id = ? AND resolved = ?", messageID, false).
+		Update("resolved", true)
+
+	if query.Error == nil && query.RowsAffected == 0 {
+		return ErrNotFound
+	}
+
+	return query.Error
+}
+
+func (s *GormStore) SetSuspended(userID uint, suspended bool) error {
+	from, to := FlagNone, FlagSuspended
+
+	if !suspended {
+		from, to = to, from
+	}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		query := tx.Model(&User{}).Where("id = ?", userID).Update("suspended", suspended)
+
+		if query.Error != nil {
+			return query.Error
+		} else if query.RowsAffected == 0 {
+			return ErrNotFound
+		}
+
+		return tx.Model(&Message{}).
+			Where("author_id = ? AND flagged = ?", userID, from).
+			Update("flagged", to).Error
+	})
+}
+
+func (s *GormStore) SetModerator(userID uint, moderator bool) error {
+	query := s.db.Model(&User{}).Where("id = ?", userID).Update("moderator", moderator)
+
+	if query.Error == nil && query.RowsAffected == 0 {
+		return ErrNotFound
+	}
+
+	return query.Error
+}
+
+func (s *GormStore) GetSession(hash string, now int64) (Session, error) {
+	var session Session
+	err := s.db.First(&session, "hash = ? AND expires_at > ?", hash, now).Error
+	return session, notFound(err)
+}
+
+func (s *GormStore) CreateSession(session *Session) error {
+	return s.db.Create(session).Error
+}
+
+func (s *GormStore) UpdateSession(session *Session) error {
+	query := s.db.Model(&Session{}).Where("hash = ?", session.Hash).Updates(map[string]interface{}{
+		"user_id":    session.UserID,
+		"data":       session.Data,
+		"user_agent": session.UserAgent,
+		"last_seen":  session.LastSeen,
+		"expires_at": session.ExpiresAt,
+	})
+
+	if query.Error == nil && query.RowsAffected == 0 {
+		return ErrNotFound
+	}
+
+	return query.Error
+}
+
+func (s *GormStore) TouchSession(hash string, lastSeen, expiresAt int64) error {
+	return s.db.Model(&Session{}).Where("hash = ?", hash).Updates(map[string]interface{}{
+		"last_seen":  lastSeen,
+		"expires_at": expiresAt,
+	}).Error
+}
+
+func (s *GormStore) DeleteSession(hash string) error {
+	return s.db.Where("hash = ?", hash).Delete(&Session{}).Error
+}
+
+func (s *GormStore) DeleteExpiredSessions(now int64) error {
+	return s.db.Where("expires_at <= ?", now).Delete(&Session{}).Error
+}
+
+func (s *GormStore) GetUserSessions(userID uint, now int64) ([]Session, error) {
+	var sessions []Session
+	err := s.db.Order("last_seen desc").
+		Find(&sessions, "user_id = ? AND expires_at > ?", userID, now).Error
+	return sessions, err
+}
+
+func (s *GormStore) DeleteUserSession(userID, id uint) error {
+	query := s.db.Where("id = ? AND user_id = ?", id, userID).Delete(&Session{})
+
+	if query.Error == nil && query.RowsAffected == 0 {
+		return ErrNotFound
+	}
+
+	return query.Error
+}
+
+func (s *GormStore) DeleteUserSessions(userID uint, keepHash string) error {
+	return s.db.Where("user_id = ? AND hash <> ?", userID, keepHash).Delete(&Session{}).Error
+}
+
+func (s *GormStore) GetTotals(activeSince int64) (Totals, error) {
+	var totals Totals
+
+	if err := s.db.Model(&User{}).Count(&totals.Users).Error; err != nil {
+		return totals, err
+	}
+
+	if err := s.db.Model(&Message{}).Where("flagged = ?", FlagNone).Count(&totals.Messages).Error; err != nil {
+		return totals, err
+	}
+
+	if err := s.db.Model(&Follower{}).Count(&totals.Follows).Error; err != nil {
+		return totals, err
+	}
+
+	err := s.db.Model(&Message{}).
+		Where("flagged = ? AND date >= ?", FlagNone, activeSince).
+		Distinct("author_id").
+		Count(&totals.ActiveUsers).Error
+
+	return totals, err
+}
+
+// ftsColumns are the columns mirrored by the SQLite FTS5 tables, which are
+// named after their table with an _fts suffix.
+var ftsColumns = map[string]string{
+	"messages": "text",
+	"users":    "username",
+}
+
+// ftsTables returns the tables whose FTS5 table exists and can be used. Only
+// builds with the sqlite_fts5 tag create, read and write the FTS5 tables.
+func ftsTables(db *gorm.DB) map[string]bool {
+	tables := make(map[string]bool)
+
+	if db.Dialector.Name() != "sqlite" {
+		return tables
+	}
+
+	var enabled bool
+
+	if err := db.Raw("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&enabled).Error; err != nil || !enabled {
+		return tables
+	}
+
+	for table := range ftsColumns {
+		tables[table] = db.Migrator().HasTable(table + "_fts")
+	}
+
+	return tables
+}
+
+// SyncSearchIndex rebuilds the SQLite FTS5 tables that differ from the rows
+// they mirror. The stores maintain the tables rather than triggers, so that
+// builds without FTS5 can still write to the database; what those builds
+// wrote is indexed once a build with FTS5 calls this on startup.
+func SyncSearchIndex(db *gorm.DB) error {
+	for table, usable := range ftsTables(db) {
+		if !usable {
+			continue
+		}
+
+		r := strings.NewReplacer("{t}", table, "{c}", ftsColumns[table])
+		var stale bool
+
+		err := db.Raw(r.Replace("SELECT EXISTS (SELECT 1 FROM {t} LEFT JOIN {t}_fts ON {t}_fts.rowid = {t}.id " +
+			"WHERE {t}_fts.rowid IS NULL OR {t}_fts.{c} IS NOT {t}.{c}) " +
+			"OR EXISTS (SELECT 1 FROM {t}_fts WHERE rowid NOT IN (SELECT id FROM {t}))")).Scan(&stale).Error
+
+		if err != nil {
+			return err
+		} else if !stale {
+			continue
+		}
+
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Exec(r.Replace("DELETE FROM {t}_fts")).Error; err != nil {
+				return err
+			}
+
+			return tx.Exec(r.Replace("INSERT INTO {t}_fts(rowid, {c}) SELECT id, {c} FROM {t}")).Error
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// indexFTS copies the searchable column of the row id of table into the
+// table's FTS5 table, if there is one.
+func (s *GormStore) indexFTS(tx *gorm.DB, table string, id uint) error {
+	if !s.fts[table] {
+		return nil
+	}
+
+	sql := fmt.Sprintf("INSERT INTO %[1]s_fts(rowid, %[2]s) SELECT id, %[2]s FROM %[1]s WHERE id = ?", table, ftsColumns[table])
+	return tx.Exec(sql, id).Error
+}
+
+// unindexFTS removes the row id of table from the table's FTS5 table, if
+// there is one.
+func (s *GormStore) unindexFTS(tx *gorm.DB, table string, id uint) error {
+	if !s.fts[table] {
+		return nil
+	}
+
+	return tx.Exec("DELETE FROM "+table+"_fts WHERE rowid = ?", id).Error
+}
+
+// ftsQuery builds an FTS5 query that requires all terms, as prefixes if
+// prefix is set. Quoting the terms keeps FTS5 from reading them as operators.
+func ftsQuery(terms []string, prefix bool) string {
+	quoted := make([]string, len(terms))
+
+	for i, term := range terms {
+		quoted[i] = `"` + term + `"`
+
+		if prefix {
+			quoted[i] += "*"
+		}
+	}
+
+	return strings.Join(quoted, " ")
+}
+
+// likePattern matches text containing term, for databases without full-text
+// search. Terms only consist of letters, digits and underscores, so the
+// underscore is the only wildcard to escape.
+func likePattern(term string) string {
+	return "%" + strings.ReplaceAll(term, "_", `\_`) + "%"
+}
+
+// SearchMessages uses the GIN indexed tsvectors on Postgres and the FTS5
+// table on SQLite, and falls back to LIKE when SQLite lacks FTS5.
+func (s *GormStore) SearchMessages(query string, limit, offset int) ([]Message, error) {
+	terms := SearchTerms(query)
+
+	if len(terms) == 0 {
+		return nil, nil
+	}
+
+	q := s.db.Joins("Author").
+		Where("messages.flagged = ?", FlagNone).
+		Limit(limit).
+		Offset(offset)
+
+	switch {
+	case s.db.Dialector.Name() == "postgres":
+		tsquery := strings.Join(terms, " & ")
+		q = q.Where("to_tsvector('english', messages.text) @@ to_tsquery('english', ?)", tsquery).
+			Clauses(clause.OrderBy{Expression: clause.Expr{
+				SQL:                "ts_rank(to_tsvector('english', messages.text), to_tsquery('english', ?)) DESC, messages.date DESC, messages.id DESC",
+				Vars:               []interface{}{tsquery},
+				WithoutParentheses: true,
+			}})
+	case s.fts["messages"]:
+		q = q.Joins("JOIN messages_fts ON messages_fts.rowid = messages.id").
+			Where("messages_fts MATCH ?", ftsQuery(terms, false)).
+			Order("bm25(messages_fts), messages.date desc, messages.id desc")
+	default:
+		// Rank by how often the terms occur, like the in-memory store
+		var occurrences []string
+		var vars []interface{}
+
+		for _, term := range terms {
+			q = q.Where(`messages.text LIKE ? ESCAPE '\'`, likePattern(term))
+			occurrences = append(occurrences, "(LENGTH(messages.text) - LENGTH(REPLACE(LOWER(messages.text), ?, ''))) / ?")
+			vars = append(vars, term, len(term))
+		}
+
+		q = q.Clauses(clause.OrderBy{Expression: clause.Expr{
+			SQL:                strings.Join(occurrences, " + ") + " DESC, messages.date DESC, messages.id DESC",
+			Vars:               vars,
+			WithoutParentheses: true,
+		}})
+	}
+
+	var messages []Message
+
+	if err := q.Find(&messages).Error; err != nil {
+		return nil, err
+	}
+
+	return messages, s.annotate(messages)
+}
+
+func (s *GormStore) SearchUsers(query string, limit, offset int) ([]User, error) {
+	terms := SearchTerms(query)
+
+	if len(terms) == 0 {
+		return nil, nil
+	}
+
+	q := s.db.Where("users.suspended = ?", false).Limit(limit).Offset(offset)
+
+	switch {
+	case s.db.Dialector.Name() == "postgres":
+		tsquery := strings.Join(terms, ":* & ") + ":*"
+		q = q.Where("to_tsvector('simple', users.username) @@ to_tsquery('simple', ?)", tsquery).
+			Clauses(clause.OrderBy{Expression: clause.Expr{
+				SQL:                "ts_rank(to_tsvector('simple', users.username), to_tsquery('simple', ?)) DESC, LENGTH(users.username), users.username",
+				Vars:               []interface{}{tsquery},
+				WithoutParentheses: true,
+			}})
+	case s.fts["users"]:
+		q = q.Joins("JOIN users_fts ON users_fts.rowid = users.id").
+			Where("users_fts MATCH ?", ftsQuery(terms, true)).
+			Order("bm25(users_fts), LENGTH(users.username), users.username")
+	default:
+		for _, term := range terms {
+			q = q.Where(`users.username LIKE ? ESCAPE '\'`, likePattern(term))
+		}
+
+		q = q.Order("LENGTH(users.username), users.username")
+	}
+
+	var users []User
+	err := q.Find(&users).Error
+	return users, err
+}
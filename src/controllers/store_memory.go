@@ -0,0 +1,1080 @@
+package controllers
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// MemoryStore implements the stores in memory. It is meant for tests and
+// local experiments, and loses all data when the process exits.
+type MemoryStore struct {
+	mu        sync.RWMutex
+	users     []User
+	follows   map[followKey]struct{}
+	messages  []Message
+	msgID     uint
+	revisions []MessageRevision
+	mentions  []Mention
+	tags      []MessageTag
+	likes     []Like
+	latest    int
+	tokens    []Token
+	tokenID   uint
+	reports   []Report
+	sessions  []Session
+	sessionID uint
+}
+
+type followKey struct {
+	follower, follows uint
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{follows: make(map[followKey]struct{})}
+}
+
+func NewMemoryStores() Stores {
+	s := NewMemoryStore()
+	return Stores{Users: s, Follows: s, Messages: s, Likes: s, Latest: s, Tokens: s, Moderation: s, Sessions: s, Stats: s, Search: s}
+}
+
+func (s *MemoryStore) GetUserID(username string) uint {
+	user, err := s.GetUserByUsername(username)
+
+	if err != nil {
+		return 0
+	}
+
+	return user.ID
+}
+
+func (s *MemoryStore) GetUserByID(id uint) (User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, u := range s.users {
+		if u.ID == id {
+			return u, nil
+		}
+	}
+
+	return User{}, ErrNotFound
+}
+
+func (s *MemoryStore) GetUserByUsername(username string) (User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, u := range s.users {
+		if u.Username == username {
+			return u, nil
+		}
+	}
+
+	return User{}, ErrNotFound
+}
+
+func (s *MemoryStore) CreateUser(user *User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user.ID = uint(len(s.users) + 1)
+	s.users = append(s.users, *user)
+	return nil
+}
+
+func (s *MemoryStore) Follow(followerID, followsID uint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.follows[followKey{followerID, followsID}] = struct{}{}
+	return nil
+}
+
+func (s *MemoryStore) Unfollow(followerID, followsID uint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.follows, followKey{followerID, followsID})
+	return nil
+}
+
+func (s *MemoryStore) IsFollowing(followerID, followsID uint) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, ok := s.follows[followKey{followerID, followsID}]
+	return ok, nil
+}
+
+func (s *MemoryStore) GetFollowers(userID uint) ([]User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var followers []User
+
+	for _, u := range s.users {
+		if _, ok := s.follows[followKey{u.ID, userID}]; ok {
+			followers = append(followers, User{Username: u.Username})
+		}
+	}
+
+	return followers, nil
+}
+
+func (s *MemoryStore) CreateMessage(msg *Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.msgID++
+	msg.ID = s.msgID
+	s.messages = append(s.messages, *msg)
+	s.indexMentions(msg.ID, msg.Text)
+	s.indexTags(msg.ID, msg.Text)
+	return nil
+}
+
+// indexMentions records the users mentioned in the text of a message. The
+// caller must hold s.mu.
+func (s *MemoryStore) indexMentions(messageID uint, text string) {
+	for _, name := range ParseMentions(text) {
+		for _, u := range s.users {
+			if u.Username == name {
+				s.mentions = append(s.mentions, Mention{MessageID: messageID, UserID: u.ID})
+			}
+		}
+	}
+}
+
+// indexTags records the tags in the text of a message. The caller must hold
+// s.mu.
+func (s *MemoryStore) indexTags(messageID uint, text string) {
+	for _, tag := range ParseTags(text) {
+		s.tags = append(s.tags, MessageTag{MessageID: messageID, Tag: tag})
+	}
+}
+
+// unindex removes the mentions and tags of a message. The caller must hold
+// s.mu.
+func (s *MemoryStore) unindex(messageID uint) {
+	mentions := s.mentions[:0]
+
+	for _, m := range s.mentions {
+		if m.MessageID != messageID {
+			mentions = append(mentions, m)
+		}
+	}
+
+	tags := s.tags[:0]
+
+	for _, t := range s.tags {
+		if t.MessageID != messageID {
+			tags = append(tags, t)
+		}
+	}
+
+	s.mentions = mentions
+	s.tags = tags
+}
+
+// message returns the index of a message in s.messages, or -1. The caller
+// must hold s.mu.
+func (s *MemoryStore) message(id uint) int {
+	for i, m := range s.messages {
+		if m.ID == id {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func (s *MemoryStore) GetMessage(id uint) (Message, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if i := s.message(id); i >= 0 {
+		msg := s.messages[i]
+		s.annotate(&msg)
+		return msg, nil
+	}
+
+	return Message{}, ErrNotFound
+}
+
+// annotate fills in the author of a message and the fields that the GORM
+// store does not keep in the messages table. The caller must hold s.mu.
+func (s *MemoryStore) annotate(msg *Message) {
+	if i := s.user(msg.AuthorID); i >= 0 {
+		msg.Author = s.users[i]
+	}
+
+	msg.ReplyCount = 0
+	msg.Mentions = []string{}
+	msg.LikeCount = 0
+
+	for _, m := range s.messages {
+		if m.InReplyTo == msg.ID && m.Flagged == FlagNone {
+			msg.ReplyCount++
+		}
+	}
+
+	for _, m := range s.mentions {
+		if m.MessageID == msg.ID {
+			if i := s.user(m.UserID); i >= 0 {
+				msg.Mentions = append(msg.Mentions, s.users[i].Username)
+			}
+		}
+	}
+	for _, l := range s.likes {
+		if l.MessageID == msg.ID {
+			msg.LikeCount++
+		}
+	}
+}
+
+func (s *MemoryStore) UpdateMessage(id uint, text string, editedAt int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.message(id)
+
+	if i < 0 {
+		return ErrNotFound
+	}
+
+	msg := &s.messages[i]
+	revisionDate := msg.Date
+
+	if msg.EditedAt != 0 {
+		revisionDate = msg.EditedAt
+	}
+
+	s.revisions = append(s.revisions, MessageRevision{
+		ID:        uint(len(s.revisions) + 1),
+		MessageID: id,
+		Text:      msg.Text,
+		Date:      revisionDate,
+	})
+
+	msg.Text = text
+	msg.EditedAt = editedAt
+	s.unindex(id)
+	s.indexMentions(id, text)
+	s.indexTags(id, text)
+	return nil
+}
+
+func (s *MemoryStore) DeleteMessage(id uint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.message(id)
+
+	if i < 0 {
+		return ErrNotFound
+	}
+
+	s.messages = append(s.messages[:i], s.messages[i+1:]...)
+	revisions := s.revisions[:0]
+
+	for _, r := range s.revisions {
+		if r.MessageID != id {
+			revisions = append(revisions, r)
+		}
+	}
+
+	likes := s.likes[:0]
+
+	for _, l := range s.likes {
+		if l.MessageID != id {
+			likes = append(likes, l)
+		}
+	}
+
+	s.revisions = revisions
+	s.likes = likes
+	s.unindex(id)
+	return nil
+}
+
+func (s *MemoryStore) GetRevisions(messageID uint) ([]MessageRevision, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var revisions []MessageRevision
+
+	for _, r := range s.revisions {
+		if r.MessageID == messageID {
+			revisions = append(revisions, r)
+		}
+	}
+
+	return revisions, nil
+}
+
+// filterMessages returns the unflagged messages on the given page that match
+// keep, newest first. The caller must hold s.mu.
+func (s *MemoryStore) filterMessages(page Page, keep func(Message) bool) []Message {
+	var messages []Message
+
+	for _, m := range s.messages {
+		if m.Flagged == 0 && keep(m) && page.contains(m) {
+			s.annotate(&m)
+			messages = append(messages, m)
+		}
+	}
+
+	sort.Slice(messages, func(i, j int) bool {
+		return newer(messages[i], messages[j])
+	})
+
+	if page.Limit > 0 && len(messages) > page.Limit {
+		if page.After != nil {
+			messages = messages[len(messages)-page.Limit:]
+		} else {
+			messages = messages[:page.Limit]
+		}
+	}
+
+	return messages
+}
+
+func (s *MemoryStore) GetPublicMessages(page Page) ([]Message, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.filterMessages(page, func(Message) bool { return true }), nil
+}
+
+func (s *MemoryStore) GetUserMessages(userID uint, page Page) ([]Message, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.filterMessages(page, func(m Message) bool { return m.AuthorID == userID }), nil
+}
+
+func (s *MemoryStore) GetTimelineMessages(userID uint, page Page) ([]Message, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.filterMessages(page, func(m Message) bool {
+		_, follows := s.follows[followKey{userID, m.AuthorID}]
+		return m.AuthorID == userID || follows
+	}), nil
+}
+
+func (s *MemoryStore) GetMentionMessages(userID uint, page Page) ([]Message, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.filterMessages(page, func(m Message) bool {
+		for _, mention := range s.mentions {
+			if mention.MessageID == m.ID && mention.UserID == userID {
+				return true
+			}
+		}
+
+		return false
+	}), nil
+}
+
+func (s *MemoryStore) GetTagMessages(tag string, page Page) ([]Message, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.filterMessages(page, func(m Message) bool {
+		for _, t := range s.tags {
+			if t.MessageID == m.ID && t.Tag == tag {
+				return true
+			}
+		}
+
+		return false
+	}), nil
+}
+
+func (s *MemoryStore) GetLikedMessages(userID uint, page Page) ([]Message, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.filterMessages(page, func(m Message) bool {
+		for _, l := range s.likes {
+			if l.MessageID == m.ID && l.UserID == userID {
+				return true
+			}
+		}
+
+		return false
+	}), nil
+}
+
+func (s *MemoryStore) GetTrendingTags(since int64, limit int) ([]TagCount, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	counts := make(map[string]int64)
+
+	for _, t := range s.tags {
+		if i := s.message(t.MessageID); i >= 0 && s.messages[i].Date >= since && s.messages[i].Flagged == FlagNone {
+			counts[t.Tag]++
+		}
+	}
+
+	tags := make([]TagCount, 0, len(counts))
+
+	for tag, count := range counts {
+		tags = append(tags, TagCount{Tag: tag, Count: count})
+	}
+
+	sort.Slice(tags, func(i, j int) bool {
+		if tags[i].Count != tags[j].Count {
+			return tags[i].Count > tags[j].Count
+		}
+
+		return tags[i].Tag < tags[j].Tag
+	})
+
+	if len(tags) > limit {
+		tags = tags[:limit]
+	}
+
+	return tags, nil
+}
+
+func (s *MemoryStore) GetThread(id uint) ([]Message, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	i := s.message(id)
+
+	if i < 0 {
+		return nil, nil
+	}
+
+	root := s.messages[i]
+
+	for {
+		parent := s.message(root.InReplyTo)
+
+		if root.InReplyTo == 0 || parent < 0 {
+			break
+		}
+
+		root = s.messages[parent]
+	}
+
+	inThread := map[uint]bool{root.ID: true}
+
+	// Messages are stored in the order they were posted, so replies always
+	// come after the message they answer.
+	for _, m := range s.messages {
+		if inThread[m.InReplyTo] {
+			inThread[m.ID] = true
+		}
+	}
+
+	var messages []Message
+
+	for _, m := range s.messages {
+		if !inThread[m.ID] || m.Flagged != FlagNone {
+			continue
+		}
+
+		s.annotate(&m)
+		messages = append(messages, m)
+	}
+
+	sort.SliceStable(messages, func(i, j int) bool {
+		return newer(messages[j], messages[i])
+	})
+
+	return messages, nil
+}
+
+func (s *MemoryStore) Like(userID, messageID uint, date int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, l := range s.likes {
+		if l.UserID == userID && l.MessageID == messageID {
+			return nil
+		}
+	}
+
+	s.likes = append(s.likes, Like{UserID: userID, MessageID: messageID, Date: date})
+	return nil
+}
+
+func (s *MemoryStore) Unlike(userID, messageID uint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, l := range s.likes {
+		if l.UserID == userID && l.MessageID == messageID {
+			s.likes = append(s.likes[:i], s.likes[i+1:]...)
+			break
+		}
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) GetLikers(messageID uint) ([]User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var likes []Like
+
+	for _, l := range s.likes {
+		if l.MessageID == messageID {
+			likes = append(likes, l)
+		}
+	}
+
+	sort.Slice(likes, func(i, j int) bool {
+		if likes[i].Date != likes[j].Date {
+			return likes[i].Date > likes[j].Date
+		}
+
+		return likes[i].UserID < likes[j].UserID
+	})
+
+	var users []User
+
+	for _, l := range likes {
+		if i := s.user(l.UserID); i >= 0 {
+			users = append(users, s.users[i])
+		}
+	}
+
+	return users, nil
+}
+
+func (s *MemoryStore) GetLikedIDs(userID uint, messageIDs []uint) (map[uint]bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	wanted := make(map[uint]bool, len(messageIDs))
+	liked := make(map[uint]bool)
+
+	for _, id := range messageIDs {
+		wanted[id] = true
+	}
+
+	for _, l := range s.likes {
+		if l.UserID == userID && wanted[l.MessageID] {
+			liked[l.MessageID] = true
+		}
+	}
+
+	return liked, nil
+}
+
+func (s *MemoryStore) GetLatest() (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.latest, nil
+}
+
+func (s *MemoryStore) UpdateLatest(val int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if val > s.latest {
+		s.latest = val
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) CreateToken(token *Token) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tokenID++
+	token.ID = s.tokenID
+	s.tokens = append(s.tokens, *token)
+	return nil
+}
+
+func (s *MemoryStore) GetTokenByHash(hash string) (Token, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, t := range s.tokens {
+		if t.Hash == hash {
+			return t, nil
+		}
+	}
+
+	return Token{}, ErrNotFound
+}
+
+func (s *MemoryStore) GetTokens(userID uint) ([]Token, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var tokens []Token
+
+	for _, t := range s.tokens {
+		if t.UserID == userID {
+			tokens = append(tokens, t)
+		}
+	}
+
+	return tokens, nil
+}
+
+func (s *MemoryStore) DeleteToken(userID, tokenID uint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, t := range s.tokens {
+		if t.ID == tokenID && t.UserID == userID {
+			s.tokens = append(s.tokens[:i], s.tokens[i+1:]...)
+			return nil
+		}
+	}
+
+	return ErrNotFound
+}
+
+func (s *MemoryStore) ReportMessage(report *Report) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	report.ID = uint(len(s.reports) + 1)
+	s.reports = append(s.reports, *report)
+	return nil
+}
+
+// user returns the index of a user in s.users, or -1. The caller must hold
+// s.mu.
+func (s *MemoryStore) user(id uint) int {
+	for i, u := range s.users {
+		if u.ID == id {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// reportedMessages collects the messages matching keepMessage along with their
+// reports matching keepReport. The caller must hold s.mu.
+func (s *MemoryStore) reportedMessages(keepMessage func(Message) bool, keepReport func(Report) bool) []ReportedMessage {
+	var reported []ReportedMessage
+
+	for _, m := range s.messages {
+		if !keepMessage(m) {
+			continue
+		}
+
+		if i := s.user(m.AuthorID); i >= 0 {
+			m.Author = s.users[i]
+		}
+
+		rm := ReportedMessage{Message: m}
+
+		for _, r := range s.reports {
+			if r.MessageID == m.ID && keepReport(r) {
+				rm.Reports = append(rm.Reports, r)
+			}
+		}
+
+		reported = append(reported, rm)
+	}
+
+	return reported
+}
+
+func (s *MemoryStore) GetOpenReports() ([]ReportedMessage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	open := func(r Report) bool { return !r.Resolved }
+	reported := s.reportedMessages(func(m Message) bool {
+		for _, r := range s.reports {
+			if r.MessageID == m.ID && open(r) {
+				return true
+			}
+		}
+		return false
+	}, open)
+
+	return reported, nil
+}
+
+func (s *MemoryStore) GetFlaggedMessages() ([]ReportedMessage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.reportedMessages(
+		func(m Message) bool { return m.Flagged == FlagModerated },
+		func(Report) bool { return true },
+	), nil
+}
+
+// resolveReports resolves the open reports of a message and reports whether
+// there were any. The caller must hold s.mu.
+func (s *MemoryStore) resolveReports(messageID uint) bool {
+	resolved := false
+
+	for i := range s.reports {
+		if s.reports[i].MessageID == messageID && !s.reports[i].Resolved {
+			s.reports[i].Resolved = true
+			resolved = true
+		}
+	}
+
+	return resolved
+}
+
+func (s *MemoryStore) FlagMessage(id, moderatorID uint, reason string, date int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.message(id)
+
+	if i < 0 {
+		return ErrNotFound
+	}
+
+	s.messages[i].Flagged = FlagModerated
+	s.resolveReports(id)
+	s.reports = append(s.reports, Report{
+		ID:         uint(len(s.reports) + 1),
+		MessageID:  id,
+		ReporterID: moderatorID,
+		Reason:     reason,
+		Date:       date,
+		Resolved:   true,
+	})
+
+	return nil
+}
+
+func (s *MemoryStore) UnflagMessage(id uint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.message(id)
+
+	if i < 0 || s.messages[i].Flagged != FlagModerated {
+		return ErrNotFound
+	}
+
+	s.messages[i].Flagged = FlagNone
+
+	if u := s.user(s.messages[i].AuthorID); u >= 0 && s.users[u].Suspended {
+		s.messages[i].Flagged = FlagSuspended
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) DismissReports(messageID uint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.resolveReports(messageID) {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) SetSuspended(userID uint, suspended bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.user(userID)
+
+	if i < 0 {
+		return ErrNotFound
+	}
+
+	from, to := FlagNone, FlagSuspended
+
+	if !suspended {
+		from, to = to, from
+	}
+
+	s.users[i].Suspended = suspended
+
+	for j := range s.messages {
+		if s.messages[j].AuthorID == userID && s.messages[j].Flagged == from {
+			s.messages[j].Flagged = to
+		}
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) SetModerator(userID uint, moderator bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.user(userID)
+
+	if i < 0 {
+		return ErrNotFound
+	}
+
+	s.users[i].Moderator = moderator
+	return nil
+}
+
+func (s *MemoryStore) GetSession(hash string, now int64) (Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, session := range s.sessions {
+		if session.Hash == hash && session.ExpiresAt > now {
+			return session, nil
+		}
+	}
+
+	return Session{}, ErrNotFound
+}
+
+func (s *MemoryStore) CreateSession(session *Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sessionID++
+	session.ID = s.sessionID
+	s.sessions = append(s.sessions, *session)
+	return nil
+}
+
+func (s *MemoryStore) UpdateSession(session *Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.sessions {
+		if s.sessions[i].Hash == session.Hash {
+			session.ID = s.sessions[i].ID
+			session.CreatedAt = s.sessions[i].CreatedAt
+			s.sessions[i] = *session
+			return nil
+		}
+	}
+
+	return ErrNotFound
+}
+
+func (s *MemoryStore) TouchSession(hash string, lastSeen, expiresAt int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.sessions {
+		if s.sessions[i].Hash == hash {
+			s.sessions[i].LastSeen = lastSeen
+			s.sessions[i].ExpiresAt = expiresAt
+		}
+	}
+
+	return nil
+}
+
+// deleteSessions removes the sessions matching drop and reports whether any
+// were removed. The caller must hold s.mu.
+func (s *MemoryStore) deleteSessions(drop func(Session) bool) bool {
+	sessions := s.sessions[:0]
+
+	for _, session := range s.sessions {
+		if !drop(session) {
+			sessions = append(sessions, session)
+		}
+	}
+
+	deleted := len(sessions) != len(s.sessions)
+	s.sessions = sessions
+	return deleted
+}
+
+func (s *MemoryStore) DeleteSession(hash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.deleteSessions(func(session Session) bool { return session.Hash == hash })
+	return nil
+}
+
+func (s *MemoryStore) DeleteExpiredSessions(now int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.deleteSessions(func(session Session) bool { return session.ExpiresAt <= now })
+	return nil
+}
+
+func (s *MemoryStore) GetUserSessions(userID uint, now int64) ([]Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var sessions []Session
+
+	for _, session := range s.sessions {
+		if session.UserID == userID && session.ExpiresAt > now {
+			sessions = append(sessions, session)
+		}
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastSeen > sessions[j].LastSeen
+	})
+
+	return sessions, nil
+}
+
+func (s *MemoryStore) DeleteUserSession(userID, id uint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.deleteSessions(func(session Session) bool {
+		return session.ID == id && session.UserID == userID
+	}) {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) DeleteUserSessions(userID uint, keepHash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.deleteSessions(func(session Session) bool {
+		return session.UserID == userID && session.Hash != keepHash
+	})
+	return nil
+}
+
+func (s *MemoryStore) GetTotals(activeSince int64) (Totals, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	totals := Totals{
+		Users:   int64(len(s.users)),
+		Follows: int64(len(s.follows)),
+	}
+	active := make(map[uint]struct{})
+
+	for _, m := range s.messages {
+		if m.Flagged != FlagNone {
+			continue
+		}
+
+		totals.Messages++
+
+		if m.Date >= activeSince {
+			active[m.AuthorID] = struct{}{}
+		}
+	}
+
+	totals.ActiveUsers = int64(len(active))
+	return totals, nil
+}
+
+// SearchMessages ranks the messages by how often they contain the terms.
+func (s *MemoryStore) SearchMessages(query string, limit, offset int) ([]Message, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	terms := SearchTerms(query)
+
+	if len(terms) == 0 {
+		return nil, nil
+	}
+
+	var messages []Message
+	hits := make(map[uint]int)
+
+	for _, m := range s.messages {
+		if m.Flagged != FlagNone {
+			continue
+		}
+
+		counts := make(map[string]int)
+
+		for _, word := range searchWord.FindAllString(strings.ToLower(m.Text), -1) {
+			counts[word]++
+		}
+
+		total := 0
+
+		for _, term := range terms {
+			if counts[term] == 0 {
+				total = 0
+				break
+			}
+
+			total += counts[term]
+		}
+
+		if total > 0 {
+			s.annotate(&m)
+			messages = append(messages, m)
+			hits[m.ID] = total
+		}
+	}
+
+	sort.Slice(messages, func(i, j int) bool {
+		if a, b := hits[messages[i].ID], hits[messages[j].ID]; a != b {
+			return a > b
+		}
+
+		return newer(messages[i], messages[j])
+	})
+
+	start, end := pageBounds(len(messages), limit, offset)
+	return messages[start:end], nil
+}
+
+func (s *MemoryStore) SearchUsers(query string, limit, offset int) ([]User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	terms := SearchTerms(query)
+
+	if len(terms) == 0 {
+		return nil, nil
+	}
+
+	var users []User
+
+	for _, u := range s.users {
+		words := searchWord.FindAllString(strings.ToLower(u.Username), -1)
+		matches := !u.Suspended
+
+		for _, term := range terms {
+			found := false
+
+			for _, word := range words {
+				found = found || strings.HasPrefix(word, term)
+			}
+
+			matches = matches && found
+		}
+
+		if matches {
+			users = append(users, u)
+		}
+	}
+
+	sort.Slice(users, func(i, j int) bool {
+		if a, b := len(users[i].Username), len(users[j].Username); a != b {
+			return a < b
+		}
+
+		return users[i].Username < users[j].Username
+	})
+
+	start, end := pageBounds(len(users), limit, offset)
+	return users[start:end], nil
+}
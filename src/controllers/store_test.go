@@ -0,0 +1,384 @@
+package controllers
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"gorm.io/gorm"
+
+	"minitwit/config"
+	"minitwit/migrations"
+)
+
+// openTestDB opens a migrated SQLite database at path.
+func openTestDB(t *testing.T, path string) *gorm.DB {
+	t.Helper()
+
+	db, err := ConnectDB(config.DBConfig{Driver: "sqlite", Path: path, MaxOpenConns: 1, MaxIdleConns: 1})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := migrations.Up(db); err != nil {
+		t.Fatal(err)
+	}
+
+	sqlDB, _ := db.DB()
+	t.Cleanup(func() { sqlDB.Close() })
+	return db
+}
+
+// forEachStore runs test against the memory stores and the gorm stores over
+// an in-memory SQLite database.
+func forEachStore(t *testing.T, test func(t *testing.T, s Stores)) {
+	t.Run("memory", func(t *testing.T) {
+		test(t, NewMemoryStores())
+	})
+
+	t.Run("gorm", func(t *testing.T) {
+		test(t, NewGormStores(openTestDB(t, "file::memory:")))
+	})
+}
+
+func TestLatestNeverMovesBack(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Stores) {
+		for _, val := range []int{5, 42, 7} {
+			if err := s.Latest.UpdateLatest(val); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if latest, err := s.Latest.GetLatest(); err != nil || latest != 42 {
+			t.Errorf("GetLatest() = %d, %v, want 42", latest, err)
+		}
+	})
+}
+
+func TestLatestSurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "minitwit.db")
+
+	db := openTestDB(t, path)
+
+	if err := NewGormStores(db).Latest.UpdateLatest(42); err != nil {
+		t.Fatal(err)
+	}
+
+	sqlDB, _ := db.DB()
+	sqlDB.Close()
+
+	if latest, err := NewGormStores(openTestDB(t, path)).Latest.GetLatest(); err != nil || latest != 42 {
+		t.Errorf("GetLatest() = %d, %v after reopening, want 42", latest, err)
+	}
+}
+
+// createUsers creates users with the given names and returns their IDs.
+func createUsers(t *testing.T, s Stores, usernames ...string) []uint {
+	t.Helper()
+
+	ids := make([]uint, len(usernames))
+
+	for i, username := range usernames {
+		user := User{Username: username, Email: username + "@example.com", PwHash: "hash"}
+
+		if err := s.Users.CreateUser(&user); err != nil {
+			t.Fatal(err)
+		}
+
+		ids[i] = user.ID
+	}
+
+	return ids
+}
+
+func createMessage(t *testing.T, s Stores, msg Message) uint {
+	t.Helper()
+
+	if err := s.Messages.CreateMessage(&msg); err != nil {
+		t.Fatal(err)
+	}
+
+	return msg.ID
+}
+
+func flagOf(t *testing.T, s Stores, id uint) uint8 {
+	t.Helper()
+
+	msg, err := s.Messages.GetMessage(id)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return msg.Flagged
+}
+
+func TestModerationTransitions(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Stores) {
+		users := createUsers(t, s, "alice", "bob", "mod")
+		alice, bob, mod := users[0], users[1], users[2]
+		first := createMessage(t, s, Message{AuthorID: alice, Text: "first", Date: 1})
+		second := createMessage(t, s, Message{AuthorID: alice, Text: "second", Date: 2})
+
+		if err := s.Moderation.ReportMessage(&Report{MessageID: first, ReporterID: bob, Reason: "spam", Date: 3}); err != nil {
+			t.Fatal(err)
+		}
+
+		open, err := s.Moderation.GetOpenReports()
+
+		if err != nil || len(open) != 1 || open[0].Message.ID != first || len(open[0].Reports) != 1 {
+			t.Fatalf("GetOpenReports() = %+v, %v, want bob's report", open, err)
+		}
+
+		// Flagging hides the message and resolves its reports
+		if err := s.Moderation.FlagMessage(first, mod, "confirmed", 4); err != nil {
+			t.Fatal(err)
+		}
+
+		if flag := flagOf(t, s, first); flag != FlagModerated {
+			t.Errorf("flagged message has flag %d, want %d", flag, FlagModerated)
+		}
+
+		if open, _ := s.Moderation.GetOpenReports(); len(open) != 0 {
+			t.Errorf("got %d open reports after flagging, want 0", len(open))
+		}
+
+		flagged, err := s.Moderation.GetFlaggedMessages()
+
+		if err != nil || len(flagged) != 1 || len(flagged[0].Reports) != 2 {
+			t.Fatalf("GetFlaggedMessages() = %+v, %v, want the message with two reports", flagged, err)
+		}
+
+		for _, report := range flagged[0].Reports {
+			if !report.Resolved {
+				t.Errorf("report %+v is still open", report)
+			}
+		}
+
+		// Suspending the author hides the rest of the messages, and keeps
+		// unflagged messages hidden until the author is reinstated
+		if err := s.Moderation.SetSuspended(alice, true); err != nil {
+			t.Fatal(err)
+		}
+
+		if flag := flagOf(t, s, second); flag != FlagSuspended {
+			t.Errorf("message of a suspended user has flag %d, want %d", flag, FlagSuspended)
+		}
+
+		if err := s.Moderation.UnflagMessage(first); err != nil {
+			t.Fatal(err)
+		}
+
+		if flag := flagOf(t, s, first); flag != FlagSuspended {
+			t.Errorf("unflagged message of a suspended user has flag %d, want %d", flag, FlagSuspended)
+		}
+
+		if err := s.Moderation.SetSuspended(alice, false); err != nil {
+			t.Fatal(err)
+		}
+
+		if a, b := flagOf(t, s, first), flagOf(t, s, second); a != FlagNone || b != FlagNone {
+			t.Errorf("messages of a reinstated user have flags %d and %d, want %d", a, b, FlagNone)
+		}
+
+		// Dismissing resolves the reports but leaves the message shown
+		if err := s.Moderation.ReportMessage(&Report{MessageID: second, ReporterID: bob, Reason: "rude", Date: 5}); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := s.Moderation.DismissReports(second); err != nil {
+			t.Fatal(err)
+		}
+
+		if open, _ := s.Moderation.GetOpenReports(); len(open) != 0 {
+			t.Errorf("got %d open reports after dismissing, want 0", len(open))
+		}
+
+		if flag := flagOf(t, s, second); flag != FlagNone {
+			t.Errorf("dismissed message has flag %d, want %d", flag, FlagNone)
+		}
+
+		for _, id := range []uint{second, 999} {
+			if err := s.Moderation.DismissReports(id); err != ErrNotFound {
+				t.Errorf("DismissReports(%d) = %v, want ErrNotFound", id, err)
+			}
+		}
+	})
+}
+
+func messageIDs(messages []Message) []uint {
+	ids := make([]uint, len(messages))
+
+	for i, m := range messages {
+		ids[i] = m.ID
+	}
+
+	return ids
+}
+
+func TestThreadOrder(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Stores) {
+		users := createUsers(t, s, "alice", "bob", "mod")
+		alice, bob, mod := users[0], users[1], users[2]
+		root := createMessage(t, s, Message{AuthorID: alice, Text: "root", Date: 10})
+		createMessage(t, s, Message{AuthorID: bob, Text: "unrelated", Date: 15})
+		first := createMessage(t, s, Message{AuthorID: bob, Text: "first", Date: 20, InReplyTo: root})
+		second := createMessage(t, s, Message{AuthorID: alice, Text: "second", Date: 20, InReplyTo: root})
+		nested := createMessage(t, s, Message{AuthorID: alice, Text: "nested", Date: 30, InReplyTo: first})
+		hidden := createMessage(t, s, Message{AuthorID: bob, Text: "hidden", Date: 40, InReplyTo: first})
+
+		if err := s.Moderation.FlagMessage(hidden, mod, "spam", 50); err != nil {
+			t.Fatal(err)
+		}
+
+		// Oldest first, by ID among equal dates, from whichever message
+		want := []uint{root, first, second, nested}
+
+		for _, id := range []uint{root, second, nested} {
+			thread, err := s.Messages.GetThread(id)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := messageIDs(thread); !equalIDs(got, want) {
+				t.Errorf("GetThread(%d) = %v, want %v", id, got, want)
+			}
+
+			if thread[0].Author.Username != "alice" || thread[0].ReplyCount != 2 || thread[1].ReplyCount != 1 {
+				t.Errorf("GetThread(%d) got author %q and reply counts %d and %d", id, thread[0].Author.Username, thread[0].ReplyCount, thread[1].ReplyCount)
+			}
+		}
+
+		// Deleting the first message splits the conversation at its replies
+		if err := s.Messages.DeleteMessage(root); err != nil {
+			t.Fatal(err)
+		}
+
+		thread, err := s.Messages.GetThread(nested)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := messageIDs(thread); !equalIDs(got, []uint{first, nested}) {
+			t.Errorf("GetThread(%d) = %v after deleting the first message, want %v", nested, got, []uint{first, nested})
+		}
+	})
+}
+
+func TestMentionsFollowEdits(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Stores) {
+		users := createUsers(t, s, "alice", "bob", "carol")
+		alice, bob, carol := users[0], users[1], users[2]
+		id := createMessage(t, s, Message{AuthorID: alice, Text: "hi @bob and @nobody", Date: 1})
+
+		msg, err := s.Messages.GetMessage(id)
+
+		if err != nil || !reflect.DeepEqual(msg.Mentions, []string{"bob"}) {
+			t.Errorf("got mentions %q, %v, want only the existing user bob", msg.Mentions, err)
+		}
+
+		if err := s.Messages.UpdateMessage(id, "hi @carol", 2); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, tc := range []struct {
+			user uint
+			want []uint
+		}{{bob, nil}, {carol, []uint{id}}} {
+			messages, err := s.Messages.GetMentionMessages(tc.user, Page{Limit: 10})
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := messageIDs(messages); !equalIDs(got, tc.want) {
+				t.Errorf("GetMentionMessages(%d) = %v after the edit, want %v", tc.user, got, tc.want)
+			}
+		}
+	})
+}
+
+func TestTags(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Stores) {
+		users := createUsers(t, s, "alice", "mod")
+		alice, mod := users[0], users[1]
+		createMessage(t, s, Message{AuthorID: alice, Text: "#rust #old", Date: 5})
+		first := createMessage(t, s, Message{AuthorID: alice, Text: "#Go and #rust", Date: 100})
+		second := createMessage(t, s, Message{AuthorID: alice, Text: "more #go", Date: 200})
+		hidden := createMessage(t, s, Message{AuthorID: alice, Text: "#go #spam", Date: 300})
+
+		if err := s.Moderation.FlagMessage(hidden, mod, "spam", 400); err != nil {
+			t.Fatal(err)
+		}
+
+		messages, err := s.Messages.GetTagMessages("go", Page{Limit: 10})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got, want := messageIDs(messages), []uint{second, first}; !equalIDs(got, want) {
+			t.Errorf("GetTagMessages(go) = %v, want %v", got, want)
+		}
+
+		// Only recent, unflagged messages count
+		trending, err := s.Messages.GetTrendingTags(50, 10)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if want := []TagCount{{"go", 2}, {"rust", 1}}; !reflect.DeepEqual(trending, want) {
+			t.Errorf("GetTrendingTags() = %v, want %v", trending, want)
+		}
+
+		if trending, _ := s.Messages.GetTrendingTags(50, 1); len(trending) != 1 {
+			t.Errorf("GetTrendingTags() with limit 1 returned %d tags", len(trending))
+		}
+	})
+}
+
+func TestEditKeepsRevisions(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Stores) {
+		alice := createUsers(t, s, "alice")[0]
+		id := createMessage(t, s, Message{AuthorID: alice, Text: "frist", Date: 10})
+
+		for i, text := range []string{"first", "first!"} {
+			if err := s.Messages.UpdateMessage(id, text, int64(20+10*i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		msg, err := s.Messages.GetMessage(id)
+
+		if err != nil || msg.Text != "first!" || msg.EditedAt != 30 || msg.Date != 10 {
+			t.Errorf("got %+v, %v, want the last edit", msg, err)
+		}
+
+		// Each revision is dated when its text was written
+		revisions, err := s.Messages.GetRevisions(id)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(revisions) != 2 || revisions[0].Text != "frist" || revisions[0].Date != 10 || revisions[1].Text != "first" || revisions[1].Date != 20 {
+			t.Errorf("got revisions %+v, want frist at 10 and first at 20", revisions)
+		}
+
+		if err := s.Messages.UpdateMessage(999, "text", 40); err != ErrNotFound {
+			t.Errorf("editing an unknown message returned %v, want ErrNotFound", err)
+		}
+
+		if err := s.Messages.DeleteMessage(id); err != nil {
+			t.Fatal(err)
+		}
+
+		if revisions, err := s.Messages.GetRevisions(id); err != nil || len(revisions) != 0 {
+			t.Errorf("got revisions %+v, %v after deleting the message, want none", revisions, err)
+		}
+	})
+}
@@ -0,0 +1,78 @@
+package controllers
+
+import (
+	"regexp"
+	"strings"
+	"time"
+)
+
+// MentionPattern matches an @username in a message. The name is the first
+// submatch. Names may contain dots and dashes, but not end in them, so that
+// "@alice." at the end of a sentence mentions alice. An @ preceded by a word
+// character, as in an email address, is not a mention.
+var MentionPattern = regexp.MustCompile(`(?:^|[^\w@])@([\w.-]*\w)`)
+
+// ParseMentions returns the usernames mentioned in text, without duplicates,
+// in the order they first appear.
+func ParseMentions(text string) []string {
+	var names []string
+	seen := make(map[string]bool)
+
+	for _, match := range MentionPattern.FindAllStringSubmatch(text, -1) {
+		if name := match[1]; !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
+
+// TagPattern matches a #tag in a message. The tag is the first submatch and
+// needs at least one letter, so that "#1" is not a tag.
+var TagPattern = regexp.MustCompile(`(?:^|[^\w#])#(\w*[A-Za-z_]\w*)`)
+
+var tagName = regexp.MustCompile(`^\w*[A-Za-z_]\w*$`)
+
+// TrendingWindow is how far back trending tags are counted.
+const TrendingWindow = 24 * time.Hour
+
+// ValidTag reports whether name can be written as a #tag.
+func ValidTag(name string) bool {
+	return tagName.MatchString(name)
+}
+
+// ParseTags returns the tags in text, lower cased and without duplicates, in
+// the order they first appear.
+func ParseTags(text string) []string {
+	var tags []string
+	seen := make(map[string]bool)
+
+	for _, match := range TagPattern.FindAllStringSubmatch(text, -1) {
+		if tag := strings.ToLower(match[1]); !seen[tag] {
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+
+	return tags
+}
+
+var searchWord = regexp.MustCompile(`[\p{L}\p{N}_]+`)
+
+// SearchTerms splits a search query into lower cased words, dropping
+// punctuation and duplicates, so that the stores never have to escape
+// operators of the underlying search syntax.
+func SearchTerms(query string) []string {
+	var terms []string
+	seen := make(map[string]bool)
+
+	for _, word := range searchWord.FindAllString(strings.ToLower(query), -1) {
+		if !seen[word] {
+			seen[word] = true
+			terms = append(terms, word)
+		}
+	}
+
+	return terms
+}
@@ -0,0 +1,54 @@
+package controllers
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseMentions(t *testing.T) {
+	for text, want := range map[string][]string{
+		"":                            nil,
+		"no mentions here":            nil,
+		"@alice hi":                   {"alice"},
+		"hi @alice.":                  {"alice"},
+		"hi @alice, @bob and @alice":  {"alice", "bob"},
+		"(@first.last-name)":          {"first.last-name"},
+		"@a,@b":                       {"a", "b"},
+		"mail alice@example.com":      nil,
+		"@@alice and @ alone and @-.": nil,
+		"@Alice and @alice differ":    {"Alice", "alice"},
+		"line\n@bob":                  {"bob"},
+		"@under_score_ and @dash-":    {"under_score_", "dash"},
+	} {
+		if got := ParseMentions(text); !reflect.DeepEqual(got, want) {
+			t.Errorf("ParseMentions(%q) = %q, want %q", text, got, want)
+		}
+	}
+}
+
+func TestParseTags(t *testing.T) {
+	for text, want := range map[string][]string{
+		"":                          nil,
+		"no tags here":              nil,
+		"#Go is fun":                {"go"},
+		"love #go, #Go and #GO!":    {"go"},
+		"#go #rust_lang #x2":        {"go", "rust_lang", "x2"},
+		"#1 and #2024 are numbers":  nil,
+		"#2024_review":              {"2024_review"},
+		"issue#12 and a##b and # x": nil,
+		"(#tag)":                    {"tag"},
+		"line\n#tag":                {"tag"},
+	} {
+		if got := ParseTags(text); !reflect.DeepEqual(got, want) {
+			t.Errorf("ParseTags(%q) = %q, want %q", text, got, want)
+		}
+	}
+}
+
+func TestValidTag(t *testing.T) {
+	for name, want := range map[string]bool{"go": true, "x2": true, "_": true, "2024": false, "": false, "go-lang": false} {
+		if got := ValidTag(name); got != want {
+			t.Errorf("ValidTag(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
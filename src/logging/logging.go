@@ -0,0 +1,195 @@
+// Package logging writes structured log lines as JSON, one object per line,
+// so that filebeat can index their fields.
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+type Level string
+
+const (
+	LevelInfo  Level = "info"
+	LevelWarn  Level = "warn"
+	LevelError Level = "error"
+)
+
+type field struct {
+	key   string
+	value interface{}
+}
+
+// Logger writes JSON lines with a timestamp, level, message and its fields.
+// Loggers derived with With share the writer of their parent.
+type Logger struct {
+	mu     *sync.Mutex
+	out    io.Writer
+	fields []field
+}
+
+func New(out io.Writer) *Logger {
+	return &Logger{mu: &sync.Mutex{}, out: out}
+}
+
+var std = New(os.Stderr)
+
+// SetDefault replaces the logger used by the package level functions and by
+// FromContext for contexts without a request.
+func SetDefault(l *Logger) {
+	std = l
+}
+
+func Default() *Logger {
+	return std
+}
+
+// With returns a logger that adds the key value pairs kv to every line.
+func (l *Logger) With(kv ...interface{}) *Logger {
+	fields := make([]field, len(l.fields), len(l.fields)+len(kv)/2)
+	copy(fields, l.fields)
+
+	return &Logger{mu: l.mu, out: l.out, fields: appendPairs(fields, kv)}
+}
+
+func (l *Logger) Info(msg string, kv ...interface{})  { l.log(LevelInfo, msg, kv) }
+func (l *Logger) Warn(msg string, kv ...interface{})  { l.log(LevelWarn, msg, kv) }
+func (l *Logger) Error(msg string, kv ...interface{}) { l.log(LevelError, msg, kv) }
+
+func Info(msg string, kv ...interface{})  { std.log(LevelInfo, msg, kv) }
+func Warn(msg string, kv ...interface{})  { std.log(LevelWarn, msg, kv) }
+func Error(msg string, kv ...interface{}) { std.log(LevelError, msg, kv) }
+
+// appendPairs turns alternating keys and values into fields. Errors are
+// logged by their message, and a missing value is logged as null.
+func appendPairs(fields []field, kv []interface{}) []field {
+	for i := 0; i < len(kv); i += 2 {
+		key := fmt.Sprint(kv[i])
+		var value interface{}
+
+		if i+1 < len(kv) {
+			value = kv[i+1]
+		}
+
+		if err, ok := value.(error); ok {
+			value = err.Error()
+		}
+
+		fields = append(fields, field{key, value})
+	}
+
+	return fields
+}
+
+func (l *Logger) log(level Level, msg string, kv []interface{}) {
+	fields := append([]field{
+		{"time", time.Now().UTC().Format(time.RFC3339Nano)},
+		{"level", level},
+		{"msg", msg},
+	}, l.fields...)
+	fields = appendPairs(fields, kv)
+
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+
+	for i, f := range fields {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+
+		key, _ := json.Marshal(f.key)
+		value, err := json.Marshal(f.value)
+
+		if err != nil {
+			value, _ = json.Marshal(fmt.Sprint(f.value))
+		}
+
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+
+	buf.WriteString("}\n")
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.out.Write(buf.Bytes())
+}
+
+// RequestInfo describes the request being served. The monitoring middlewares
+// create it; handlers fill in the user once they know it.
+type RequestInfo struct {
+	mu    sync.Mutex
+	ID    string
+	Route string
+	user  string
+}
+
+func (i *RequestInfo) SetUser(user string) {
+	i.mu.Lock()
+	i.user = user
+	i.mu.Unlock()
+}
+
+func (i *RequestInfo) User() string {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.user
+}
+
+type contextKey struct{}
+
+func WithRequestInfo(ctx context.Context, info *RequestInfo) context.Context {
+	return context.WithValue(ctx, contextKey{}, info)
+}
+
+// RequestInfoFrom returns the RequestInfo of ctx, or nil outside of a request.
+func RequestInfoFrom(ctx context.Context) *RequestInfo {
+	info, _ := ctx.Value(contextKey{}).(*RequestInfo)
+	return info
+}
+
+// FromContext returns the default logger with the request ID, route and user
+// of the request in ctx and the IDs of its trace, if any.
+func FromContext(ctx context.Context) *Logger {
+	l := std
+
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		l = l.With("trace_id", sc.TraceID().String(), "span_id", sc.SpanID().String())
+	}
+
+	info := RequestInfoFrom(ctx)
+
+	if info == nil {
+		return l
+	}
+
+	l = l.With("request_id", info.ID, "route", info.Route)
+
+	if user := info.User(); user != "" {
+		l = l.With("user", user)
+	}
+
+	return l
+}
+
+// FromRequest is FromContext for the context of r.
+func FromRequest(r *http.Request) *Logger {
+	return FromContext(r.Context())
+}
+
+// SetUser records the user making the request r for its log lines.
+func SetUser(r *http.Request, user string) {
+	if info := RequestInfoFrom(r.Context()); info != nil {
+		info.SetUser(user)
+	}
+}
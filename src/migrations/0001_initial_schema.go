@@ -0,0 +1,70 @@
+package migrations
+
+import "gorm.io/gorm"
+
+// Snapshots of the models as they looked when this migration was written, so
+// that later changes to the controllers package do not alter it.
+type user0001 struct {
+	ID       uint
+	Username string `gorm:"not null"`
+	Email    string `gorm:"not null"`
+	PwHash   string `gorm:"not null"`
+}
+
+func (user0001) TableName() string { return "users" }
+
+type follower0001 struct {
+	FollowerID uint
+	FollowsID  uint
+}
+
+func (follower0001) TableName() string { return "followers" }
+
+type message0001 struct {
+	ID       uint
+	AuthorID uint   `gorm:"not null"`
+	Text     string `gorm:"not null"`
+	Date     int64
+	Flagged  uint8
+}
+
+func (message0001) TableName() string { return "messages" }
+
+// initialSchema creates the schema that ConnectDB used to AutoMigrate. A
+// database imported from the original Python MiniTwit (see
+// db_migration/pgconfig.sql) is renamed to match first.
+var initialSchema = Migration{
+	Version: 1,
+	Name:    "initial_schema",
+	Up: func(tx *gorm.DB) error {
+		legacy := []struct {
+			oldTable, newTable string
+			columns            [][2]string
+		}{
+			{"user", "users", [][2]string{{"user_id", "id"}}},
+			{"follower", "followers", [][2]string{{"who_id", "follower_id"}, {"whom_id", "follows_id"}}},
+			{"message", "messages", [][2]string{{"message_id", "id"}, {"pub_date", "date"}}},
+		}
+
+		for _, l := range legacy {
+			renamed, err := renameTable(tx, l.oldTable, l.newTable)
+
+			if err != nil {
+				return err
+			} else if !renamed {
+				continue
+			}
+
+			for _, c := range l.columns {
+				if err := renameColumn(tx, l.newTable, c[0], c[1]); err != nil {
+					return err
+				}
+			}
+		}
+
+		return tx.Migrator().AutoMigrate(&user0001{}, &follower0001{}, &message0001{})
+	},
+	Down: func(tx *gorm.DB) error {
+		return tx.Migrator().DropTable(&message0001{}, &follower0001{}, &user0001{})
+	},
+}
@@ -0,0 +1,27 @@
+package migrations
+
+import "gorm.io/gorm"
+
+type latest0002 struct {
+	ID    uint `gorm:"primaryKey"`
+	Value int  `gorm:"not null;default:0"`
+}
+
+func (latest0002) TableName() string { return "latest" }
+
+// latestCounter stores the simulator's "latest" value, which the API used to
+// keep in memory.
+var latestCounter = Migration{
+	Version: 2,
+	Name:    "latest_counter",
+	Up: func(tx *gorm.DB) error {
+		if err := tx.Migrator().CreateTable(&latest0002{}); err != nil {
+			return err
+		}
+
+		return tx.Create(&latest0002{ID: 1, Value: 0}).Error
+	},
+	Down: func(tx *gorm.DB) error {
+		return tx.Migrator().DropTable(&latest0002{})
+	},
+}
@@ -0,0 +1,27 @@
+package migrations
+
+import "gorm.io/gorm"
+
+type token0003 struct {
+	ID        uint
+	UserID    uint   `gorm:"not null;index"`
+	Name      string `gorm:"not null"`
+	Hash      string `gorm:"not null;uniqueIndex"`
+	Scopes    string `gorm:"not null"`
+	CreatedAt int64
+}
+
+func (token0003) TableName() string { return "tokens" }
+
+// apiTokens adds the per-user API tokens that replace the shared SIM_AUTH
+// secret.
+var apiTokens = Migration{
+	Version: 3,
+	Name:    "api_tokens",
+	Up: func(tx *gorm.DB) error {
+		return tx.Migrator().CreateTable(&token0003{})
+	},
+	Down: func(tx *gorm.DB) error {
+		return tx.Migrator().DropTable(&token0003{})
+	},
+}
@@ -0,0 +1,39 @@
+package migrations
+
+import "gorm.io/gorm"
+
+type message0004 struct {
+	EditedAt int64 `gorm:"not null;default:0"`
+}
+
+func (message0004) TableName() string { return "messages" }
+
+type messageRevision0004 struct {
+	ID        uint
+	MessageID uint   `gorm:"not null;index"`
+	Text      string `gorm:"not null"`
+	Date      int64
+}
+
+func (messageRevision0004) TableName() string { return "message_revisions" }
+
+// messageRevisions lets authors edit their messages while keeping the earlier
+// texts.
+var messageRevisions = Migration{
+	Version: 4,
+	Name:    "message_revisions",
+	Up: func(tx *gorm.DB) error {
+		if err := tx.Migrator().AddColumn(&message0004{}, "EditedAt"); err != nil {
+			return err
+		}
+
+		return tx.Migrator().CreateTable(&messageRevision0004{})
+	},
+	Down: func(tx *gorm.DB) error {
+		if err := tx.Migrator().DropTable(&messageRevision0004{}); err != nil {
+			return err
+		}
+
+		return tx.Migrator().DropColumn(&message0004{}, "EditedAt")
+	},
+}
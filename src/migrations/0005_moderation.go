@@ -0,0 +1,50 @@
+package migrations
+
+import "gorm.io/gorm"
+
+type user0005 struct {
+	Moderator bool `gorm:"not null;default:false"`
+	Suspended bool `gorm:"not null;default:false"`
+}
+
+func (user0005) TableName() string { return "users" }
+
+type report0005 struct {
+	ID         uint
+	MessageID  uint   `gorm:"not null;index"`
+	ReporterID uint   `gorm:"not null"`
+	Reason     string `gorm:"not null"`
+	Date       int64
+	Resolved   bool `gorm:"not null;default:false"`
+}
+
+func (report0005) TableName() string { return "reports" }
+
+// moderation adds the moderator and suspended flags on users and the reports
+// filed against messages.
+var moderation = Migration{
+	Version: 5,
+	Name:    "moderation",
+	Up: func(tx *gorm.DB) error {
+		for _, column := range []string{"Moderator", "Suspended"} {
+			if err := tx.Migrator().AddColumn(&user0005{}, column); err != nil {
+				return err
+			}
+		}
+
+		return tx.Migrator().CreateTable(&report0005{})
+	},
+	Down: func(tx *gorm.DB) error {
+		if err := tx.Migrator().DropTable(&report0005{}); err != nil {
+			return err
+		}
+
+		for _, column := range []string{"Suspended", "Moderator"} {
+			if err := tx.Migrator().DropColumn(&user0005{}, column); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	},
+}
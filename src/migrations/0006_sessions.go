@@ -0,0 +1,28 @@
+package migrations
+
+import "gorm.io/gorm"
+
+type session0006 struct {
+	ID        uint
+	Hash      string `gorm:"not null;uniqueIndex"`
+	UserID    uint   `gorm:"not null;index"`
+	Data      string `gorm:"not null"`
+	UserAgent string
+	CreatedAt int64
+	LastSeen  int64
+	ExpiresAt int64 `gorm:"index"`
+}
+
+func (session0006) TableName() string { return "sessions" }
+
+// webSessions moves the web sessions out of the cookie into the database.
+var webSessions = Migration{
+	Version: 6,
+	Name:    "sessions",
+	Up: func(tx *gorm.DB) error {
+		return tx.Migrator().CreateTable(&session0006{})
+	},
+	Down: func(tx *gorm.DB) error {
+		return tx.Migrator().DropTable(&session0006{})
+	},
+}
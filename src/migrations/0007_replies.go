@@ -0,0 +1,30 @@
+package migrations
+
+import "gorm.io/gorm"
+
+type message0007 struct {
+	InReplyTo uint `gorm:"not null;default:0;index"`
+}
+
+func (message0007) TableName() string { return "messages" }
+
+// replies links messages to the message they answer, so that conversations
+// can be shown as threads.
+var replies = Migration{
+	Version: 7,
+	Name:    "replies",
+	Up: func(tx *gorm.DB) error {
+		if err := tx.Migrator().AddColumn(&message0007{}, "InReplyTo"); err != nil {
+			return err
+		}
+
+		return tx.Migrator().CreateIndex(&message0007{}, "InReplyTo")
+	},
+	Down: func(tx *gorm.DB) error {
+		if err := tx.Migrator().DropIndex(&message0007{}, "InReplyTo"); err != nil {
+			return err
+		}
+
+		return tx.Migrator().DropColumn(&message0007{}, "InReplyTo")
+	},
+}
@@ -0,0 +1,23 @@
+package migrations
+
+import "gorm.io/gorm"
+
+type mention0008 struct {
+	MessageID uint `gorm:"primaryKey;autoIncrement:false"`
+	UserID    uint `gorm:"primaryKey;autoIncrement:false;index"`
+}
+
+func (mention0008) TableName() string { return "mentions" }
+
+// mentions records which users a message mentions with @username, for the
+// mentions timelines.
+var mentions = Migration{
+	Version: 8,
+	Name:    "mentions",
+	Up: func(tx *gorm.DB) error {
+		return tx.Migrator().CreateTable(&mention0008{})
+	},
+	Down: func(tx *gorm.DB) error {
+		return tx.Migrator().DropTable(&mention0008{})
+	},
+}
@@ -0,0 +1,23 @@
+package migrations
+
+import "gorm.io/gorm"
+
+type messageTag0009 struct {
+	MessageID uint   `gorm:"primaryKey;autoIncrement:false"`
+	Tag       string `gorm:"primaryKey;index"`
+}
+
+func (messageTag0009) TableName() string { return "message_tags" }
+
+// messageTags indexes the #tags of messages for the tag pages and trending
+// tags.
+var messageTags = Migration{
+	Version: 9,
+	Name:    "message_tags",
+	Up: func(tx *gorm.DB) error {
+		return tx.Migrator().CreateTable(&messageTag0009{})
+	},
+	Down: func(tx *gorm.DB) error {
+		return tx.Migrator().DropTable(&messageTag0009{})
+	},
+}
@@ -0,0 +1,92 @@
+package migrations
+
+import (
+	"errors"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// ftsTables are the SQLite FTS5 tables mirroring the searchable columns. The
+// stores keep them up to date when they write the rows. Message text is
+// stemmed like the english configuration does on Postgres.
+var ftsTables = []struct{ table, column, tokenize string }{
+	{"messages", "text", "porter unicode61"},
+	{"users", "username", "unicode61"},
+}
+
+// search adds the full-text indexes used by the search. Postgres gets GIN
+// indexes over the tsvectors that the queries compute. SQLite gets FTS5
+// tables, provided the binary was built with the sqlite_fts5 tag; otherwise
+// nothing is created and the search falls back to LIKE. Roll back and apply
+// this migration again with an FTS5 build to add the tables later. Builds
+// without FTS5 can still write to a database with the tables; what they
+// write is indexed when a build with FTS5 next starts.
+var search = Migration{
+	Version: 10,
+	Name:    "search",
+	Up: func(tx *gorm.DB) error {
+		switch tx.Dialector.Name() {
+		case "postgres":
+			if err := tx.Exec("CREATE INDEX idx_messages_text_search ON messages USING GIN (to_tsvector('english', text))").Error; err != nil {
+				return err
+			}
+
+			return tx.Exec("CREATE INDEX idx_users_username_search ON users USING GIN (to_tsvector('simple', username))").Error
+		case "sqlite":
+			if !hasFTS5(tx) {
+				return nil
+			}
+
+			for _, t := range ftsTables {
+				statements := []string{
+					"CREATE VIRTUAL TABLE {t}_fts USING fts5({c}, tokenize='{k}')",
+					"INSERT INTO {t}_fts(rowid, {c}) SELECT id, {c} FROM {t}",
+				}
+
+				for _, statement := range statements {
+					sql := strings.NewReplacer("{t}", t.table, "{c}", t.column, "{k}", t.tokenize).Replace(statement)
+
+					if err := tx.Exec(sql).Error; err != nil {
+						return err
+					}
+				}
+			}
+		}
+
+		return nil
+	},
+	Down: func(tx *gorm.DB) error {
+		switch tx.Dialector.Name() {
+		case "postgres":
+			if err := tx.Exec("DROP INDEX IF EXISTS idx_users_username_search").Error; err != nil {
+				return err
+			}
+
+			return tx.Exec("DROP INDEX IF EXISTS idx_messages_text_search").Error
+		case "sqlite":
+			for _, t := range ftsTables {
+				if !tx.Migrator().HasTable(t.table + "_fts") {
+					continue
+				}
+
+				if !hasFTS5(tx) {
+					return errors.New("the search tables can only be dropped by a build with the sqlite_fts5 tag")
+				}
+
+				if err := tx.Exec("DROP TABLE " + t.table + "_fts").Error; err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	},
+}
+
+// hasFTS5 reports whether the SQLite library was compiled with FTS5.
+func hasFTS5(tx *gorm.DB) bool {
+	var enabled bool
+	err := tx.Raw("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&enabled).Error
+	return err == nil && enabled
+}
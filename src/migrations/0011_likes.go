@@ -0,0 +1,24 @@
+package migrations
+
+import "gorm.io/gorm"
+
+type like0011 struct {
+	UserID    uint  `gorm:"primaryKey;autoIncrement:false"`
+	MessageID uint  `gorm:"primaryKey;autoIncrement:false;index"`
+	Date      int64 `gorm:"not null"`
+}
+
+func (like0011) TableName() string { return "likes" }
+
+// likes lets users like messages. The composite key keeps a user from liking
+// a message twice.
+var likes = Migration{
+	Version: 11,
+	Name:    "likes",
+	Up: func(tx *gorm.DB) error {
+		return tx.Migrator().CreateTable(&like0011{})
+	},
+	Down: func(tx *gorm.DB) error {
+		return tx.Migrator().DropTable(&like0011{})
+	},
+}
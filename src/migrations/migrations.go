@@ -0,0 +1,245 @@
+// Package migrations keeps the MiniTwit database schema up to date through
+// numbered up/down migrations. Applied versions are recorded in the
+// schema_migrations table.
+package migrations
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(tx *gorm.DB) error
+	Down    func(tx *gorm.DB) error
+}
+
+type Status struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+type schemaMigration struct {
+	Version   int `gorm:"primaryKey;autoIncrement:false"`
+	Name      string
+	AppliedAt int64
+}
+
+func (schemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// Arbitrary key for the Postgres advisory lock that keeps several replicas
+// from migrating at the same time.
+const lockKey = 7_357_001
+
+// All migrations in the order they are applied. Versions must be unique and
+// increasing, and a migration must never change once it has been released.
+var all = []Migration{
+	initialSchema,
+	latestCounter,
+	apiTokens,
+	messageRevisions,
+	moderation,
+	webSessions,
+	replies,
+	mentions,
+	messageTags,
+	search,
+	likes,
+}
+
+func All() []Migration {
+	return append([]Migration(nil), all...)
+}
+
+func applied(db *gorm.DB) (map[int]schemaMigration, error) {
+	if err := db.AutoMigrate(&schemaMigration{}); err != nil {
+		return nil, err
+	}
+
+	var rows []schemaMigration
+
+	if err := db.Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	versions := make(map[int]schemaMigration, len(rows))
+
+	for _, row := range rows {
+		versions[row.Version] = row
+	}
+
+	return versions, nil
+}
+
+func lock(tx *gorm.DB) error {
+	if tx.Dialector.Name() != "postgres" {
+		return nil
+	}
+
+	return tx.Exec("SELECT pg_advisory_xact_lock(?)", lockKey).Error
+}
+
+func isApplied(tx *gorm.DB, version int) (bool, error) {
+	var count int64
+	err := tx.Model(&schemaMigration{}).Where("version = ?", version).Count(&count).Error
+	return count > 0, err
+}
+
+// Up applies all pending migrations and returns the ones it applied.
+func Up(db *gorm.DB) ([]Migration, error) {
+	versions, err := applied(db)
+
+	if err != nil {
+		return nil, fmt.Errorf("migrations: reading schema_migrations: %w", err)
+	}
+
+	var done []Migration
+
+	for _, m := range all {
+		if _, ok := versions[m.Version]; ok {
+			continue
+		}
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := lock(tx); err != nil {
+				return err
+			}
+
+			// Another replica may have applied it while we waited for the lock
+			if ok, err := isApplied(tx, m.Version); err != nil || ok {
+				return err
+			}
+
+			if err := m.Up(tx); err != nil {
+				return err
+			}
+
+			return tx.Create(&schemaMigration{
+				Version:   m.Version,
+				Name:      m.Name,
+				AppliedAt: time.Now().Unix(),
+			}).Error
+		})
+
+		if err != nil {
+			return done, fmt.Errorf("migrations: applying %d_%s: %w", m.Version, m.Name, err)
+		}
+
+		done = append(done, m)
+	}
+
+	return done, nil
+}
+
+// Down rolls back the most recently applied migration. It returns nil if no
+// migration has been applied.
+func Down(db *gorm.DB) (*Migration, error) {
+	versions, err := applied(db)
+
+	if err != nil {
+		return nil, fmt.Errorf("migrations: reading schema_migrations: %w", err)
+	}
+
+	for i := len(all) - 1; i >= 0; i-- {
+		m := all[i]
+
+		if _, ok := versions[m.Version]; !ok {
+			continue
+		}
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := lock(tx); err != nil {
+				return err
+			}
+
+			if err := m.Down(tx); err != nil {
+				return err
+			}
+
+			return tx.Delete(&schemaMigration{}, "version = ?", m.Version).Error
+		})
+
+		if err != nil {
+			return nil, fmt.Errorf("migrations: rolling back %d_%s: %w", m.Version, m.Name, err)
+		}
+
+		return &m, nil
+	}
+
+	return nil, nil
+}
+
+// Statuses reports every known migration and whether it has been applied.
+func Statuses(db *gorm.DB) ([]Status, error) {
+	versions, err := applied(db)
+
+	if err != nil {
+		return nil, fmt.Errorf("migrations: reading schema_migrations: %w", err)
+	}
+
+	statuses := make([]Status, 0, len(all))
+
+	for _, m := range all {
+		row, ok := versions[m.Version]
+		status := Status{Migration: m, Applied: ok}
+
+		if ok {
+			status.AppliedAt = time.Unix(row.AppliedAt, 0)
+		}
+
+		statuses = append(statuses, status)
+	}
+
+	return statuses, nil
+}
+
+// Pending returns the known migrations that have not been applied. Unlike
+// Statuses it only reads schema_migrations, so it is cheap enough for
+// readiness checks.
+func Pending(db *gorm.DB) ([]Migration, error) {
+	var versions []int
+
+	if err := db.Model(&schemaMigration{}).Pluck("version", &versions).Error; err != nil {
+		return nil, fmt.Errorf("migrations: reading schema_migrations: %w", err)
+	}
+
+	done := make(map[int]bool, len(versions))
+
+	for _, v := range versions {
+		done[v] = true
+	}
+
+	var pending []Migration
+
+	for _, m := range all {
+		if !done[m.Version] {
+			pending = append(pending, m)
+		}
+	}
+
+	return pending, nil
+}
+
+// renameTable renames a table if it exists under its old name and has not
+// been renamed yet.
+func renameTable(tx *gorm.DB, oldName, newName string) (bool, error) {
+	m := tx.Migrator()
+
+	if !m.HasTable(oldName) || m.HasTable(newName) {
+		return false, nil
+	}
+
+	return true, m.RenameTable(oldName, newName)
+}
+
+func renameColumn(tx *gorm.DB, table, oldName, newName string) error {
+	return tx.Exec("ALTER TABLE ? RENAME COLUMN ? TO ?",
+		clause.Table{Name: table}, clause.Column{Name: oldName}, clause.Column{Name: newName}).Error
+}
@@ -0,0 +1,184 @@
+package migrations
+
+import (
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func openDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sqlDB, err := db.DB()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every connection would open its own in-memory database
+	sqlDB.SetMaxOpenConns(1)
+	sqlDB.SetMaxIdleConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+	return db
+}
+
+func appliedCount(t *testing.T, db *gorm.DB) int {
+	t.Helper()
+
+	statuses, err := Statuses(db)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(statuses) != len(all) {
+		t.Fatalf("got %d statuses, want %d", len(statuses), len(all))
+	}
+
+	n := 0
+
+	for i, s := range statuses {
+		if s.Version != all[i].Version {
+			t.Errorf("status %d is version %d, want %d", i, s.Version, all[i].Version)
+		}
+
+		if s.Applied {
+			n++
+		}
+	}
+
+	return n
+}
+
+func TestVersionsIncrease(t *testing.T) {
+	for i := 1; i < len(all); i++ {
+		if all[i].Version <= all[i-1].Version {
+			t.Errorf("version %d follows version %d", all[i].Version, all[i-1].Version)
+		}
+	}
+}
+
+func TestUpAndDown(t *testing.T) {
+	db := openDB(t)
+
+	if n := appliedCount(t, db); n != 0 {
+		t.Fatalf("a new database has %d applied migrations", n)
+	}
+
+	done, err := Up(db)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(done) != len(all) || appliedCount(t, db) != len(all) {
+		t.Fatalf("Up applied %d migrations, want %d", len(done), len(all))
+	}
+
+	if pending, err := Pending(db); err != nil || len(pending) != 0 {
+		t.Fatalf("Pending() = %v, %v after Up", pending, err)
+	}
+
+	if done, err := Up(db); err != nil || len(done) != 0 {
+		t.Fatalf("Up() = %v, %v on an up to date database", done, err)
+	}
+
+	// Roll back one migration at a time, newest first
+	for i := len(all) - 1; i >= 0; i-- {
+		m, err := Down(db)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if m == nil || m.Version != all[i].Version {
+			t.Fatalf("Down rolled back %v, want version %d", m, all[i].Version)
+		}
+
+		pending, err := Pending(db)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(pending) != len(all)-i || pending[0].Version != all[i].Version {
+			t.Fatalf("after rolling back version %d got %d pending migrations", all[i].Version, len(pending))
+		}
+	}
+
+	if m, err := Down(db); err != nil || m != nil {
+		t.Fatalf("Down() = %v, %v with nothing applied", m, err)
+	}
+
+	for _, table := range []string{"users", "followers", "messages"} {
+		if db.Migrator().HasTable(table) {
+			t.Errorf("table %s is left after rolling back everything", table)
+		}
+	}
+
+	// And back up again
+	if done, err := Up(db); err != nil || len(done) != len(all) {
+		t.Fatalf("Up() = %d migrations, %v after rolling back", len(done), err)
+	}
+}
+
+func TestUpRenamesLegacySchema(t *testing.T) {
+	db := openDB(t)
+
+	for _, sql := range []string{
+		`CREATE TABLE user (user_id INTEGER PRIMARY KEY, username TEXT NOT NULL, email TEXT NOT NULL, pw_hash TEXT NOT NULL)`,
+		`CREATE TABLE follower (who_id INTEGER, whom_id INTEGER)`,
+		`CREATE TABLE message (message_id INTEGER PRIMARY KEY, author_id INTEGER NOT NULL, text TEXT NOT NULL, pub_date INTEGER, flagged INTEGER)`,
+		`INSERT INTO user VALUES (7, 'alice', 'alice@example.com', 'hash'), (8, 'bob', 'bob@example.com', 'hash')`,
+		`INSERT INTO follower VALUES (7, 8)`,
+		`INSERT INTO message VALUES (3, 8, 'hello', 1700000000, 0)`,
+	} {
+		if err := db.Exec(sql).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := Up(db); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, table := range []string{"user", "follower", "message"} {
+		if db.Migrator().HasTable(table) {
+			t.Errorf("legacy table %s was not renamed", table)
+		}
+	}
+
+	var username string
+	db.Raw("SELECT username FROM users WHERE id = 7").Scan(&username)
+
+	if username != "alice" {
+		t.Errorf("got user 7 %q, want alice", username)
+	}
+
+	var follows uint
+	db.Raw("SELECT follows_id FROM followers WHERE follower_id = 7").Scan(&follows)
+
+	if follows != 8 {
+		t.Errorf("got user 7 following %d, want 8", follows)
+	}
+
+	var message struct {
+		AuthorID uint
+		Text     string
+		Date     int64
+	}
+
+	db.Raw("SELECT author_id, text, date FROM messages WHERE id = 3").Scan(&message)
+
+	if message.AuthorID != 8 || message.Text != "hello" || message.Date != 1700000000 {
+		t.Errorf("got message 3 %+v", message)
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"gorm.io/gorm"
+
+	"minitwit/config"
+	ctrl "minitwit/controllers"
+	"minitwit/migrations"
+)
+
+const usage = `Usage: minitwit <command>
+
+Commands:
+  migrate up                   Apply all pending database migrations
+  migrate down                 Roll back the most recently applied migration
+  migrate status               List the migrations and whether they have been applied
+  moderator grant <username>   Make a user a moderator
+  moderator revoke <username>  Take the moderator role away from a user
+
+The database is configured like the app and API, through MINITWIT_CONFIG
+and the DB_* environment variables.
+`
+
+func main() {
+	if len(os.Args) < 3 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	cfg, err := config.Load()
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading configuration: %s\n", err)
+		os.Exit(1)
+	}
+
+	db, err := ctrl.ConnectDB(cfg.DB)
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+
+	switch command := strings.Join(os.Args[1:3], " "); {
+	case command == "migrate up" && len(os.Args) == 3:
+		err = migrateUp(db)
+	case command == "migrate down" && len(os.Args) == 3:
+		err = migrateDown(db)
+	case command == "migrate status" && len(os.Args) == 3:
+		err = migrateStatus(db)
+	case command == "moderator grant" && len(os.Args) == 4:
+		err = setModerator(db, os.Args[3], true)
+	case command == "moderator revoke" && len(os.Args) == 4:
+		err = setModerator(db, os.Args[3], false)
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+}
+
+func migrateUp(db *gorm.DB) error {
+	done, err := migrations.Up(db)
+
+	for _, m := range done {
+		fmt.Printf("Applied %04d_%s\n", m.Version, m.Name)
+	}
+
+	if err == nil && len(done) == 0 {
+		fmt.Println("Database is up to date")
+	}
+
+	return err
+}
+
+func migrateDown(db *gorm.DB) error {
+	m, err := migrations.Down(db)
+
+	if err != nil {
+		return err
+	}
+
+	if m == nil {
+		fmt.Println("No migrations to roll back")
+	} else {
+		fmt.Printf("Rolled back %04d_%s\n", m.Version, m.Name)
+	}
+
+	return nil
+}
+
+func migrateStatus(db *gorm.DB) error {
+	statuses, err := migrations.Statuses(db)
+
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "VERSION\tNAME\tAPPLIED AT")
+
+	for _, s := range statuses {
+		appliedAt := "pending"
+
+		if s.Applied {
+			appliedAt = s.AppliedAt.Format("2006-01-02 15:04:05")
+		}
+
+		fmt.Fprintf(tw, "%04d\t%s\t%s\n", s.Version, s.Name, appliedAt)
+	}
+
+	return tw.Flush()
+}
+
+func setModerator(db *gorm.DB, username string, moderator bool) error {
+	stores := ctrl.NewGormStores(db)
+	userID := stores.Users.GetUserID(username)
+
+	if userID == 0 {
+		return fmt.Errorf("no user named %q", username)
+	}
+
+	if err := stores.Moderation.SetModerator(userID, moderator); err != nil {
+		return err
+	}
+
+	if moderator {
+		fmt.Printf("%s is now a moderator\n", username)
+	} else {
+		fmt.Printf("%s is no longer a moderator\n", username)
+	}
+
+	return nil
+}
@@ -0,0 +1,70 @@
+package monitoring
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"minitwit/logging"
+)
+
+// RequestIDHeader carries the request ID. An ID sent by the client or a proxy
+// is kept, so that one request can be followed across services.
+const RequestIDHeader = "X-Request-ID"
+
+// validRequestID accepts up to 64 letters, digits, dashes and underscores, so
+// that clients cannot inject arbitrary text into the logs.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+
+	return true
+}
+
+func newRequestID() string {
+	bytes := make([]byte, 16)
+	rand.Read(bytes)
+	return hex.EncodeToString(bytes)
+}
+
+// MiddlewareAccessLog assigns each request an ID, makes it available to the
+// handlers' loggers through the request context and writes one access log
+// line per request.
+func MiddlewareAccessLog(router *mux.Router, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		id := r.Header.Get(RequestIDHeader)
+
+		if !validRequestID(id) {
+			id = newRequestID()
+			r.Header.Set(RequestIDHeader, id)
+		}
+
+		w.Header().Set(RequestIDHeader, id)
+
+		info, r := requestInfo(router, r)
+		info.ID = id
+		recorder := NewStatusRecorder(w)
+
+		h.ServeHTTP(recorder, r)
+
+		logging.FromRequest(r).Info("request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", recorder.StatusCode(),
+			"latency", time.Since(start).Seconds(),
+			"remote_addr", r.RemoteAddr,
+			"user_agent", r.UserAgent(),
+		)
+	})
+}
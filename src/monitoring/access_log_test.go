@@ -0,0 +1,84 @@
+package monitoring
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"minitwit/logging"
+)
+
+func TestValidRequestID(t *testing.T) {
+	for id, want := range map[string]bool{
+		"":                         false,
+		"3f2a9c1e-7b4d_X":          true,
+		strings.Repeat("a", 64):    true,
+		strings.Repeat("a", 65):    false,
+		"id with spaces":           false,
+		"id\n{\"level\":\"fake\"}": false,
+		"ünïcode":                  false,
+	} {
+		if got := validRequestID(id); got != want {
+			t.Errorf("validRequestID(%q) = %v, want %v", id, got, want)
+		}
+	}
+}
+
+func TestAccessLogRequestID(t *testing.T) {
+	var out bytes.Buffer
+	logging.SetDefault(logging.New(&out))
+
+	router := mux.NewRouter()
+	router.HandleFunc("/api/msgs/{username}", func(w http.ResponseWriter, r *http.Request) {
+		logging.FromRequest(r).Info("handler")
+	})
+	h := MiddlewareAccessLog(router, router)
+
+	for _, tc := range []struct {
+		sent string
+		kept bool
+	}{
+		{"client-id_1", true},
+		{"bad id\nwith a newline", false},
+		{"", false},
+	} {
+		out.Reset()
+		req := httptest.NewRequest("GET", "/api/msgs/alice", nil)
+
+		if tc.sent != "" {
+			req.Header.Set(RequestIDHeader, tc.sent)
+		}
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		id := rec.Header().Get(RequestIDHeader)
+
+		if tc.kept && id != tc.sent || !tc.kept && (len(id) != 32 || !validRequestID(id)) {
+			t.Errorf("sent %q, got %q back", tc.sent, id)
+		}
+
+		// Both the handler's line and the access log line carry the ID
+		lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+
+		if len(lines) != 2 {
+			t.Fatalf("got %d log lines, want 2: %s", len(lines), out.String())
+		}
+
+		for _, line := range lines {
+			var fields map[string]interface{}
+
+			if err := json.Unmarshal([]byte(line), &fields); err != nil {
+				t.Fatal(err)
+			}
+
+			if fields["request_id"] != id || fields["route"] != "/api/msgs/{username}" {
+				t.Errorf("got log line %s, want request_id %s", line, id)
+			}
+		}
+	}
+}
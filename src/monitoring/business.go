@@ -0,0 +1,112 @@
+package monitoring
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"minitwit/logging"
+)
+
+// Sources label the business metrics with the binary that emitted them.
+const (
+	SourceApp = "app"
+	SourceAPI = "api"
+)
+
+var (
+	usersRegistered = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "minitwit_users_registered_total",
+		Help: "The number of users registered",
+	}, []string{"source"})
+
+	messagesPosted = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "minitwit_messages_posted_total",
+		Help: "The number of messages posted",
+	}, []string{"source"})
+
+	followEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "minitwit_follow_events_total",
+		Help: "The number of follow and unfollow actions",
+	}, []string{"source", "action"})
+
+	logins = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "minitwit_logins_total",
+		Help: "The number of password logins by result",
+	}, []string{"source", "result"})
+
+	totalUsers = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "minitwit_users",
+		Help: "The number of registered users",
+	})
+
+	totalMessages = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "minitwit_messages",
+		Help: "The number of visible messages",
+	})
+
+	totalFollows = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "minitwit_follows",
+		Help: "The number of follow relations",
+	})
+
+	activeUsers = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "minitwit_active_users",
+		Help: "The number of users who posted within the last 24 hours",
+	})
+)
+
+func UserRegistered(source string) {
+	usersRegistered.WithLabelValues(source).Inc()
+}
+
+func MessagePosted(source string) {
+	messagesPosted.WithLabelValues(source).Inc()
+}
+
+func Followed(source string) {
+	followEvents.WithLabelValues(source, "follow").Inc()
+}
+
+func Unfollowed(source string) {
+	followEvents.WithLabelValues(source, "unfollow").Inc()
+}
+
+func LoginSucceeded(source string) {
+	logins.WithLabelValues(source, "success").Inc()
+}
+
+func LoginFailed(source string) {
+	logins.WithLabelValues(source, "failure").Inc()
+}
+
+// Totals are the values of the total gauges.
+type Totals struct {
+	Users       int64
+	Messages    int64
+	Follows     int64
+	ActiveUsers int64
+}
+
+// activeWindow is how far back a user must have posted to count as active.
+const activeWindow = 24 * time.Hour
+
+// TrackTotals sets the total gauges from load now and then every interval.
+// load receives the start of the active user window. It never returns.
+func TrackTotals(interval time.Duration, load func(activeSince int64) (Totals, error)) {
+	for {
+		totals, err := load(time.Now().Add(-activeWindow).Unix())
+
+		if err != nil {
+			logging.Error("Error in database lookup", "func", "TrackTotals", "error", err)
+		} else {
+			totalUsers.Set(float64(totals.Users))
+			totalMessages.Set(float64(totals.Messages))
+			totalFollows.Set(float64(totals.Follows))
+			activeUsers.Set(float64(totals.ActiveUsers))
+		}
+
+		time.Sleep(interval)
+	}
+}
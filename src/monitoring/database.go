@@ -0,0 +1,119 @@
+package monitoring
+
+import (
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"gorm.io/gorm"
+)
+
+// queryBuckets are the upper bounds, in seconds, of the query duration
+// histogram. Queries are expected to be well below the request durations.
+var queryBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 5}
+
+var queryLabels = []string{"operation", "table"}
+
+var (
+	dbQueryCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "db_queries_total",
+		Help: "The total number of database statements run through GORM",
+	}, queryLabels)
+
+	dbQueryErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "db_query_errors_total",
+		Help: "The number of database statements that failed, not counting empty results",
+	}, queryLabels)
+
+	dbQueryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "db_query_duration_seconds",
+		Help:    "Duration distribution of database statements run through GORM",
+		Buckets: queryBuckets,
+	}, queryLabels)
+)
+
+const queryStartKey = "monitoring:query_start"
+
+// DBMetrics is a GORM plugin that records the count, errors and duration of
+// every statement by operation and table.
+type DBMetrics struct{}
+
+func (DBMetrics) Name() string {
+	return "monitoring:metrics"
+}
+
+func (DBMetrics) Initialize(db *gorm.DB) error {
+	cb := db.Callback()
+	errs := []error{
+		cb.Create().Before("gorm:create").Register("monitoring:before_create", startQuery),
+		cb.Create().After("gorm:create").Register("monitoring:after_create", observeQuery("create")),
+		cb.Query().Before("gorm:query").Register("monitoring:before_query", startQuery),
+		cb.Query().After("gorm:query").Register("monitoring:after_query", observeQuery("query")),
+		cb.Update().Before("gorm:update").Register("monitoring:before_update", startQuery),
+		cb.Update().After("gorm:update").Register("monitoring:after_update", observeQuery("update")),
+		cb.Delete().Before("gorm:delete").Register("monitoring:before_delete", startQuery),
+		cb.Delete().After("gorm:delete").Register("monitoring:after_delete", observeQuery("delete")),
+		cb.Row().Before("gorm:row").Register("monitoring:before_row", startQuery),
+		cb.Row().After("gorm:row").Register("monitoring:after_row", observeQuery("row")),
+		cb.Raw().Before("gorm:raw").Register("monitoring:before_raw", startQuery),
+		cb.Raw().After("gorm:raw").Register("monitoring:after_raw", observeQuery("raw")),
+	}
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func startQuery(db *gorm.DB) {
+	db.InstanceSet(queryStartKey, time.Now())
+}
+
+func observeQuery(operation string) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		value, ok := db.InstanceGet(queryStartKey)
+
+		if !ok {
+			return
+		}
+
+		table := db.Statement.Table
+
+		if table == "" {
+			table = "unknown"
+		}
+
+		labels := prometheus.Labels{"operation": operation, "table": table}
+		dbQueryCount.With(labels).Inc()
+		dbQueryDuration.With(labels).Observe(time.Since(value.(time.Time)).Seconds())
+
+		if db.Error != nil && !errors.Is(db.Error, gorm.ErrRecordNotFound) {
+			dbQueryErrors.With(labels).Inc()
+		}
+	}
+}
+
+// InstrumentDB installs the DBMetrics and DBTracing plugins on db and exposes
+// the statistics of its connection pool as go_sql_* gauges labelled with name.
+func InstrumentDB(db *gorm.DB, name string) error {
+	if err := db.Use(DBMetrics{}); err != nil {
+		return err
+	}
+
+	if err := db.Use(DBTracing{}); err != nil {
+		return err
+	}
+
+	sqlDB, err := db.DB()
+
+	if err != nil {
+		return err
+	}
+
+	return prometheus.Register(collectors.NewDBStatsCollector(sqlDB, name))
+}
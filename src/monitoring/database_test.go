@@ -0,0 +1,101 @@
+package monitoring
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+type item struct {
+	ID   uint
+	Name string
+}
+
+func TestInstrumentDB(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sqlDB, _ := db.DB()
+	sqlDB.SetMaxOpenConns(1)
+	defer sqlDB.Close()
+
+	// Unique, since the pool collector stays registered after the test
+	name := fmt.Sprintf("test_%d", time.Now().UnixNano())
+
+	if err := InstrumentDB(db, name); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.AutoMigrate(&item{}); err != nil {
+		t.Fatal(err)
+	}
+
+	created := dbQueryCount.WithLabelValues("create", "items")
+	queried := dbQueryCount.WithLabelValues("query", "items")
+	failedQueries := dbQueryErrors.WithLabelValues("query", "items")
+	failedRaw := dbQueryErrors.WithLabelValues("raw", "unknown")
+	counts := []float64{testutil.ToFloat64(created), testutil.ToFloat64(queried), testutil.ToFloat64(failedQueries), testutil.ToFloat64(failedRaw)}
+
+	db.Create(&item{Name: "one"})
+
+	// A missing row is an empty result rather than a failure
+	if err := db.First(&item{}, 42).Error; !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("got %v, want ErrRecordNotFound", err)
+	}
+
+	if err := db.Exec("SELECT * FROM nowhere").Error; err == nil {
+		t.Fatal("querying a missing table succeeded")
+	}
+
+	for i, tc := range []struct {
+		name    string
+		counter prometheus.Counter
+		want    float64
+	}{
+		{"create items", created, 1},
+		{"query items", queried, 1},
+		{"failed query items", failedQueries, 0},
+		{"failed raw", failedRaw, 1},
+	} {
+		if got := testutil.ToFloat64(tc.counter) - counts[i]; got != tc.want {
+			t.Errorf("%s counted %v times, want %v", tc.name, got, tc.want)
+		}
+	}
+
+	// The pool statistics are exported under the name of the database
+	families, err := prometheus.DefaultGatherer.Gather()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, family := range families {
+		if family.GetName() != "go_sql_max_open_connections" {
+			continue
+		}
+
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "db_name" && label.GetValue() == name {
+					if got := metric.GetGauge().GetValue(); got != 1 {
+						t.Errorf("go_sql_max_open_connections is %v, want 1", got)
+					}
+
+					return
+				}
+			}
+		}
+	}
+
+	t.Errorf("no go_sql_max_open_connections series for %s", name)
+}
@@ -0,0 +1,130 @@
+package monitoring
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+
+	"minitwit/migrations"
+)
+
+// checkTimeout bounds every readiness check, so that a hanging database
+// makes the instance unready instead of stalling the probe.
+const checkTimeout = 2 * time.Second
+
+// Check is one readiness condition, such as the database being reachable.
+type Check struct {
+	Name string
+	Run  func(ctx context.Context) error
+}
+
+type checkResult struct {
+	Name    string  `json:"name"`
+	Status  string  `json:"status"`
+	Latency float64 `json:"latency"` // seconds
+	Error   string  `json:"error,omitempty"`
+}
+
+type healthResponse struct {
+	Status string        `json:"status"`
+	Checks []checkResult `json:"checks"`
+}
+
+func writeHealth(w http.ResponseWriter, code int, response healthResponse) {
+	body, _ := json.Marshal(response)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	w.Write(body)
+}
+
+// Healthz reports that the process is up and serving HTTP. It checks nothing
+// else, so that a failing database does not get the container restarted.
+func Healthz(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, 200, healthResponse{Status: "ok", Checks: []checkResult{}})
+}
+
+// Readyz runs all checks concurrently and responds 200 if they pass and 503
+// otherwise, listing the status and latency of each check.
+func Readyz(checks ...Check) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), checkTimeout)
+		defer cancel()
+
+		results := make([]checkResult, len(checks))
+		var wg sync.WaitGroup
+
+		for i, check := range checks {
+			wg.Add(1)
+
+			go func(i int, check Check) {
+				defer wg.Done()
+
+				start := time.Now()
+				err := check.Run(ctx)
+				results[i] = checkResult{Name: check.Name, Status: "ok", Latency: time.Since(start).Seconds()}
+
+				if err != nil {
+					results[i].Status = "failed"
+					results[i].Error = err.Error()
+				}
+			}(i, check)
+		}
+
+		wg.Wait()
+
+		response := healthResponse{Status: "ok", Checks: results}
+		code := 200
+
+		for _, result := range results {
+			if result.Status != "ok" {
+				response.Status = "unavailable"
+				code = 503
+			}
+		}
+
+		writeHealth(w, code, response)
+	}
+}
+
+// DatabaseCheck pings the database.
+func DatabaseCheck(db *gorm.DB) Check {
+	return Check{Name: "database", Run: func(ctx context.Context) error {
+		sqlDB, err := db.DB()
+
+		if err != nil {
+			return err
+		}
+
+		return sqlDB.PingContext(ctx)
+	}}
+}
+
+// MigrationsCheck fails while any known migration has not been applied.
+func MigrationsCheck(db *gorm.DB) Check {
+	return Check{Name: "migrations", Run: func(ctx context.Context) error {
+		pending, err := migrations.Pending(db.WithContext(ctx))
+
+		if err != nil {
+			return err
+		}
+
+		if len(pending) > 0 {
+			names := make([]string, len(pending))
+
+			for i, m := range pending {
+				names[i] = fmt.Sprintf("%04d_%s", m.Version, m.Name)
+			}
+
+			return fmt.Errorf("pending migrations: %s", strings.Join(names, ", "))
+		}
+
+		return nil
+	}}
+}
@@ -0,0 +1,84 @@
+package monitoring
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"minitwit/migrations"
+)
+
+func readiness(t *testing.T, checks ...Check) (int, healthResponse) {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	Readyz(checks...).ServeHTTP(rec, httptest.NewRequest("GET", "/readyz", nil))
+
+	var response healthResponse
+
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+
+	return rec.Code, response
+}
+
+func TestHealthz(t *testing.T) {
+	rec := httptest.NewRecorder()
+	Healthz(rec, httptest.NewRequest("GET", "/healthz", nil))
+
+	if rec.Code != 200 || rec.Header().Get("Cache-Control") != "no-store" {
+		t.Errorf("got status %d and headers %v", rec.Code, rec.Header())
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sqlDB, _ := db.DB()
+	sqlDB.SetMaxOpenConns(1)
+	failing := Check{Name: "failing", Run: func(context.Context) error { return errors.New("broken") }}
+
+	if code, response := readiness(t, DatabaseCheck(db), failing); code != 503 || response.Status != "unavailable" ||
+		response.Checks[0].Status != "ok" || response.Checks[1].Status != "failed" || response.Checks[1].Error != "broken" {
+		t.Errorf("got %d %+v, want only the failing check to fail", code, response)
+	}
+
+	if _, err := migrations.Up(db); err != nil {
+		t.Fatal(err)
+	}
+
+	if code, response := readiness(t, DatabaseCheck(db), MigrationsCheck(db)); code != 200 || response.Status != "ok" {
+		t.Errorf("got %d %+v on a migrated database, want 200", code, response)
+	}
+
+	m, err := migrations.Down(db)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code, response := readiness(t, MigrationsCheck(db))
+
+	if code != 503 || !strings.Contains(response.Checks[0].Error, fmt.Sprintf("%04d_%s", m.Version, m.Name)) {
+		t.Errorf("got %d %+v, want the rolled back migration reported", code, response)
+	}
+
+	sqlDB.Close()
+
+	if code, response := readiness(t, DatabaseCheck(db)); code != 503 || response.Checks[0].Status != "failed" {
+		t.Errorf("got %d %+v on a closed database, want 503", code, response)
+	}
+}
@@ -2,69 +2,177 @@ package monitoring
 
 import (
 	"net/http"
+	"strconv"
 	"time"
 
+	"github.com/gorilla/mux"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
-	"github.com/shirou/gopsutil/cpu"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"minitwit/logging"
 )
 
-var (
-	apiCPUGauge = promauto.NewGauge(prometheus.GaugeOpts{
-		Name: "api_cpu_load",
-		Help: "The CPU load percentage for the MiniTwit API",
-	})
+// MetricsHandler serves /metrics for Prometheus on a mux of its own, so that
+// the metrics port exposes nothing else.
+func MetricsHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{
+		// OpenMetrics is needed to expose the trace exemplars.
+		EnableOpenMetrics: true,
+	}))
 
-	appCPUGauge = promauto.NewGauge(prometheus.GaugeOpts{
-		Name: "app_cpu_load",
-		Help: "The CPU load percentage for the MiniTwit app",
-	})
+	return mux
+}
+
+// requestBuckets are the upper bounds, in seconds, of the request duration
+// histograms.
+var requestBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
 
-	apiRequestCount = promauto.NewCounter(prometheus.CounterOpts{
-		Name: "api_request_count",
+var requestLabels = []string{"route", "method", "code"}
+
+var (
+	apiRequestCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "api_requests_total",
 		Help: "The total number of processed HTTP requests by the MiniTwit API",
-	})
+	}, requestLabels)
 
-	appRequestCount = promauto.NewCounter(prometheus.CounterOpts{
-		Name: "app_request_count",
+	appRequestCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "app_requests_total",
 		Help: "The total number of processed HTTP requests by the MiniTwit app",
-	})
+	}, requestLabels)
 
-	apiRequestDurationSummary = promauto.NewHistogram(prometheus.HistogramOpts{
-		Name: "api_request_duration",
-		Help: "Request duration distribution for HTTP requests to the MiniTwit API",
-	})
+	apiRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "api_request_duration_seconds",
+		Help:    "Request duration distribution for HTTP requests to the MiniTwit API",
+		Buckets: requestBuckets,
+	}, requestLabels)
 
-	appRequestDurationSummary = promauto.NewHistogram(prometheus.HistogramOpts{
-		Name: "app_request_duration",
-		Help: "Request duration distribution for HTTP requests to the MiniTwit app",
-	})
+	appRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "app_request_duration_seconds",
+		Help:    "Request duration distribution for HTTP requests to the MiniTwit app",
+		Buckets: requestBuckets,
+	}, requestLabels)
 )
 
-func MiddlewareMetrics(h http.Handler, isApi bool) http.Handler {
+// StatusRecorder is a ResponseWriter that remembers the status code written
+// to it. Like net/http, it treats the first WriteHeader or Write as final.
+type StatusRecorder struct {
+	http.ResponseWriter
+	Status int
+}
+
+func NewStatusRecorder(w http.ResponseWriter) *StatusRecorder {
+	return &StatusRecorder{ResponseWriter: w}
+}
+
+func (r *StatusRecorder) WriteHeader(status int) {
+	if r.Status == 0 {
+		r.Status = status
+	}
+
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *StatusRecorder) Write(b []byte) (int, error) {
+	if r.Status == 0 {
+		r.Status = http.StatusOK
+	}
+
+	return r.ResponseWriter.Write(b)
+}
+
+// Flush lets streaming handlers flush through the recorder.
+func (r *StatusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// StatusCode returns the recorded status, which is 200 if the handler wrote
+// nothing.
+func (r *StatusRecorder) StatusCode() int {
+	if r.Status == 0 {
+		return http.StatusOK
+	}
+
+	return r.Status
+}
+
+// requestInfo returns the RequestInfo of r. The outermost middleware creates
+// it with the route of r, so that the route is matched once per request
+// however the middlewares are combined.
+func requestInfo(router *mux.Router, r *http.Request) (*logging.RequestInfo, *http.Request) {
+	if info := logging.RequestInfoFrom(r.Context()); info != nil {
+		return info, r
+	}
+
+	info := &logging.RequestInfo{Route: RouteTemplate(router, r)}
+	return info, r.WithContext(logging.WithRequestInfo(r.Context(), info))
+}
+
+// RouteTemplate returns the path template of the route matching r, such as
+// "/api/msgs/{username}", or "unmatched". Labelling by template rather than
+// path keeps the number of series bounded.
+func RouteTemplate(router *mux.Router, r *http.Request) string {
+	var match mux.RouteMatch
+
+	if router.Match(r, &match) && match.Route != nil {
+		if tmpl, err := match.Route.GetPathTemplate(); err == nil {
+			return tmpl
+		}
+	}
+
+	return "unmatched"
+}
+
+// requestMethod maps methods outside of the standard set to "OTHER", so that
+// clients cannot create arbitrary label values.
+func requestMethod(r *http.Request) string {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+		http.MethodPatch, http.MethodDelete, http.MethodOptions:
+		return r.Method
+	}
+
+	return "OTHER"
+}
+
+// MiddlewareMetrics counts and times the requests served by router. A panic in
+// a handler is recovered and counted as a 500.
+func MiddlewareMetrics(router *mux.Router, isApi bool) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// BEFORE REQUEST
 		start := time.Now()
-		cpuUsage, err := cpu.Percent(0, false)
-
-		if err == nil {
-			if isApi {
-				apiCPUGauge.Set(cpuUsage[0])
-			} else {
-				appCPUGauge.Set(cpuUsage[0])
-			}
-		}
+		info, r := requestInfo(router, r)
+		route := info.Route
 
 		// REQUEST
-		h.ServeHTTP(w, r)
+		recorder := NewStatusRecorder(w)
+		serveRecovered(router, recorder, r)
 
 		// AFTER REQUEST
+		labels := prometheus.Labels{
+			"route":  route,
+			"method": requestMethod(r),
+			"code":   strconv.Itoa(recorder.StatusCode()),
+		}
+		duration := time.Since(start).Seconds()
+		count, histogram := appRequestCount, appRequestDuration
+
 		if isApi {
-			apiRequestCount.Inc()
-			apiRequestDurationSummary.Observe(float64(time.Since(start)))
+			count, histogram = apiRequestCount, apiRequestDuration
+		}
+
+		count.With(labels).Inc()
+		observer := histogram.With(labels)
+
+		// Link the observation to its trace, so a slow bucket leads to an
+		// example request.
+		if id := traceID(r.Context()); id != "" {
+			observer.(prometheus.ExemplarObserver).ObserveWithExemplar(duration, prometheus.Labels{"trace_id": id})
 		} else {
-			appRequestCount.Inc()
-			appRequestDurationSummary.Observe(float64(time.Since(start)))
+			observer.Observe(duration)
 		}
 	})
 }
@@ -0,0 +1,65 @@
+package monitoring
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"minitwit/logging"
+)
+
+func TestRouteIsMatchedOnce(t *testing.T) {
+	logging.SetDefault(logging.New(io.Discard))
+
+	matches := 0
+	router := mux.NewRouter()
+	router.MatcherFunc(func(*http.Request, *mux.RouteMatch) bool {
+		matches++
+		return true
+	}).HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	h := MiddlewareTracing(router, MiddlewareAccessLog(router, MiddlewareMetrics(router, false)))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	// Once for the route template and once by the router serving the request
+	if matches != 2 {
+		t.Errorf("route matched %d times, want 2", matches)
+	}
+}
+
+func TestMetricsAreLabelledByRoute(t *testing.T) {
+	logging.SetDefault(logging.New(io.Discard))
+
+	router := mux.NewRouter()
+	router.HandleFunc("/api/msgs/{username}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(204)
+	})
+	router.HandleFunc("/api/panic", func(w http.ResponseWriter, r *http.Request) {
+		panic("test")
+	})
+
+	h := MiddlewareMetrics(router, true)
+
+	for _, tc := range []struct {
+		method, path         string
+		route, label, status string
+	}{
+		{"GET", "/api/msgs/alice", "/api/msgs/{username}", "GET", "204"},
+		{"GET", "/api/msgs/bob", "/api/msgs/{username}", "GET", "204"},
+		{"BREW", "/api/msgs/alice", "/api/msgs/{username}", "OTHER", "204"},
+		{"GET", "/api/nowhere", "unmatched", "GET", "404"},
+		{"GET", "/api/panic", "/api/panic", "GET", "500"},
+	} {
+		counter := apiRequestCount.WithLabelValues(tc.route, tc.label, tc.status)
+		before := testutil.ToFloat64(counter)
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(tc.method, tc.path, nil))
+
+		if got := testutil.ToFloat64(counter) - before; got != 1 {
+			t.Errorf("%s %s counted %v times as %s %s %s, want once", tc.method, tc.path, got, tc.route, tc.label, tc.status)
+		}
+	}
+}
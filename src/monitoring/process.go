@@ -0,0 +1,53 @@
+package monitoring
+
+import (
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/shirou/gopsutil/load"
+	"github.com/shirou/gopsutil/process"
+
+	"minitwit/logging"
+)
+
+var (
+	processCPU = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "minitwit_process_cpu_percent",
+		Help: "CPU used by the process since the previous sample, in percent of one core",
+	})
+
+	hostLoad = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "minitwit_host_load",
+		Help: "The host's load average",
+	}, []string{"period"})
+)
+
+// CollectProcessMetrics samples the process CPU and the host load every
+// interval, so that no sampling happens while serving requests. They are what
+// the default registry's process and Go collectors lack; memory, file
+// descriptors and goroutines come from those. It never returns.
+func CollectProcessMetrics(interval time.Duration) {
+	proc, err := process.NewProcess(int32(os.Getpid()))
+
+	if err != nil {
+		logging.Error("Error in reading process", "func", "CollectProcessMetrics", "error", err)
+		return
+	}
+
+	// The first sample only sets the baseline for the CPU percentage.
+	proc.Percent(0)
+
+	for range time.Tick(interval) {
+		if cpu, err := proc.Percent(0); err == nil {
+			processCPU.Set(cpu)
+		}
+
+		if avg, err := load.Avg(); err == nil {
+			hostLoad.WithLabelValues("1m").Set(avg.Load1)
+			hostLoad.WithLabelValues("5m").Set(avg.Load5)
+			hostLoad.WithLabelValues("15m").Set(avg.Load15)
+		}
+	}
+}
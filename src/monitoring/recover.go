@@ -0,0 +1,35 @@
+package monitoring
+
+import (
+	"errors"
+	"net/http"
+	"runtime/debug"
+
+	"minitwit/logging"
+)
+
+// serveRecovered serves r with h and turns a panic in h into a 500 response,
+// so that the request is logged, counted and traced as a failed request
+// instead of the connection being dropped.
+func serveRecovered(h http.Handler, w *StatusRecorder, r *http.Request) {
+	defer func() {
+		err := recover()
+
+		if err == nil {
+			return
+		}
+
+		// Handlers abort a response on purpose with ErrAbortHandler
+		if e, ok := err.(error); ok && errors.Is(e, http.ErrAbortHandler) {
+			panic(err)
+		}
+
+		logging.FromRequest(r).Error("Panic in handler", "error", err, "stack", string(debug.Stack()))
+
+		if w.Status == 0 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}()
+
+	h.ServeHTTP(w, r)
+}
@@ -0,0 +1,202 @@
+package monitoring
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+
+	"minitwit/config"
+)
+
+var tracer = otel.Tracer("minitwit")
+
+// SetupTracing installs the global tracer provider for service, exporting to
+// the exporter chosen in cfg, and W3C trace context propagation. The returned
+// function flushes and stops the exporter.
+func SetupTracing(service string, cfg config.TracingConfig) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	var exporter sdktrace.SpanExporter
+	var err error
+
+	switch cfg.Exporter {
+	case "none":
+		return func(context.Context) error { return nil }, nil
+	case "stdout":
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case "file":
+		var f *os.File
+
+		if f, err = os.OpenFile(cfg.File, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600); err != nil {
+			return nil, fmt.Errorf("tracing: opening %s: %w", cfg.File, err)
+		}
+
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(f))
+	case "otlp":
+		opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(cfg.Endpoint)}
+
+		if cfg.Insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+
+		exporter, err = otlptracehttp.New(context.Background(), opts...)
+	default:
+		return nil, fmt.Errorf("tracing: unsupported exporter %q", cfg.Exporter)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("tracing: creating %s exporter: %w", cfg.Exporter, err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceNameKey.String(service))),
+	)
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+// MiddlewareTracing starts a server span for every request, named after the
+// matched route and continuing the trace of an incoming traceparent header.
+func MiddlewareTracing(router *mux.Router, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info, r := requestInfo(router, r)
+		route := info.Route
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, r.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPMethodKey.String(r.Method),
+				semconv.HTTPRouteKey.String(route),
+				semconv.HTTPTargetKey.String(r.URL.Path),
+				semconv.HTTPUserAgentKey.String(r.UserAgent()),
+			),
+		)
+		defer span.End()
+
+		recorder := NewStatusRecorder(w)
+		h.ServeHTTP(recorder, r.WithContext(ctx))
+
+		status := recorder.StatusCode()
+		span.SetAttributes(semconv.HTTPStatusCodeKey.Int(status))
+
+		if status >= 500 {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+	})
+}
+
+// traceID returns the ID of the sampled trace in ctx, or "".
+func traceID(ctx context.Context) string {
+	sc := trace.SpanContextFromContext(ctx)
+
+	if !sc.IsSampled() {
+		return ""
+	}
+
+	return sc.TraceID().String()
+}
+
+const querySpanKey = "monitoring:query_span"
+
+// DBTracing is a GORM plugin that records every statement as a child span of
+// the span in the statement's context. Statements run outside of a traced
+// request, such as background jobs, are not traced.
+type DBTracing struct{}
+
+func (DBTracing) Name() string {
+	return "monitoring:tracing"
+}
+
+func (DBTracing) Initialize(db *gorm.DB) error {
+	cb := db.Callback()
+	errs := []error{
+		cb.Create().Before("gorm:create").Register("monitoring:start_create_span", startSpan("create")),
+		cb.Create().After("gorm:create").Register("monitoring:end_create_span", endSpan),
+		cb.Query().Before("gorm:query").Register("monitoring:start_query_span", startSpan("query")),
+		cb.Query().After("gorm:query").Register("monitoring:end_query_span", endSpan),
+		cb.Update().Before("gorm:update").Register("monitoring:start_update_span", startSpan("update")),
+		cb.Update().After("gorm:update").Register("monitoring:end_update_span", endSpan),
+		cb.Delete().Before("gorm:delete").Register("monitoring:start_delete_span", startSpan("delete")),
+		cb.Delete().After("gorm:delete").Register("monitoring:end_delete_span", endSpan),
+		cb.Row().Before("gorm:row").Register("monitoring:start_row_span", startSpan("row")),
+		cb.Row().After("gorm:row").Register("monitoring:end_row_span", endSpan),
+		cb.Raw().Before("gorm:raw").Register("monitoring:start_raw_span", startSpan("raw")),
+		cb.Raw().After("gorm:raw").Register("monitoring:end_raw_span", endSpan),
+	}
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func startSpan(operation string) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		ctx := db.Statement.Context
+
+		if ctx == nil || !trace.SpanContextFromContext(ctx).IsValid() {
+			return
+		}
+
+		name := "gorm." + operation
+
+		if db.Statement.Table != "" {
+			name += " " + db.Statement.Table
+		}
+
+		_, span := tracer.Start(ctx, name,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(
+				semconv.DBSystemKey.String(db.Dialector.Name()),
+				semconv.DBOperationKey.String(operation),
+				semconv.DBSQLTableKey.String(db.Statement.Table),
+			),
+		)
+		db.InstanceSet(querySpanKey, span)
+	}
+}
+
+func endSpan(db *gorm.DB) {
+	value, ok := db.InstanceGet(querySpanKey)
+
+	if !ok {
+		return
+	}
+
+	span := value.(trace.Span)
+	span.SetAttributes(
+		semconv.DBStatementKey.String(db.Statement.SQL.String()),
+		attribute.Int64("db.rows_affected", db.RowsAffected),
+	)
+
+	if db.Error != nil && !errors.Is(db.Error, gorm.ErrRecordNotFound) {
+		span.RecordError(db.Error)
+		span.SetStatus(codes.Error, db.Error.Error())
+	}
+
+	span.End()
+}
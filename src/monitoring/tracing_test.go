@@ -0,0 +1,69 @@
+package monitoring
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestTracingSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Use(DBTracing{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Outside of a request, and so not traced
+	if err := db.AutoMigrate(&item{}); err != nil {
+		t.Fatal(err)
+	}
+
+	router := mux.NewRouter()
+	router.HandleFunc("/api/msgs/{username}", func(w http.ResponseWriter, r *http.Request) {
+		var items []item
+		db.WithContext(r.Context()).Find(&items)
+		w.WriteHeader(500)
+	})
+
+	req := httptest.NewRequest("GET", "/api/msgs/alice", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	MiddlewareTracing(router, router).ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := recorder.Ended()
+
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, want the query and the request", len(spans))
+	}
+
+	query, server := spans[0], spans[1]
+
+	if server.Name() != "GET /api/msgs/{username}" || server.Status().Code != codes.Error {
+		t.Errorf("got server span %q with status %v", server.Name(), server.Status())
+	}
+
+	if server.SpanContext().TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" || server.Parent().SpanID().String() != "00f067aa0ba902b7" {
+		t.Errorf("server span does not continue the incoming trace")
+	}
+
+	if query.Name() != "gorm.query items" || query.Parent().SpanID() != server.SpanContext().SpanID() {
+		t.Errorf("got query span %q, want a child of the server span", query.Name())
+	}
+}
@@ -0,0 +1,87 @@
+// Package server runs the HTTP servers of the MiniTwit binaries and shuts them
+// down gracefully on SIGINT or SIGTERM, so that deploys do not cut off
+// in-flight requests.
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"minitwit/config"
+	"minitwit/logging"
+)
+
+// New returns a server for handler on port with the timeouts of cfg.
+func New(port int, handler http.Handler, cfg config.ServerConfig) *http.Server {
+	return &http.Server{
+		Addr:         "0.0.0.0:" + strconv.Itoa(port),
+		Handler:      handler,
+		ReadTimeout:  time.Duration(cfg.ReadTimeout),
+		WriteTimeout: time.Duration(cfg.WriteTimeout),
+		IdleTimeout:  time.Duration(cfg.IdleTimeout),
+	}
+}
+
+// Run serves on all servers until the process receives SIGINT or SIGTERM or
+// one of them fails. It then stops accepting connections and waits up to
+// timeout for in-flight requests. It returns the error that stopped the
+// servers, or nil after a signal and a clean shutdown.
+func Run(timeout time.Duration, servers ...*http.Server) error {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	errs := make(chan error, len(servers))
+
+	for _, srv := range servers {
+		go func(srv *http.Server) {
+			if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				errs <- fmt.Errorf("server: serving on %s: %w", srv.Addr, err)
+			}
+		}(srv)
+	}
+
+	var err error
+
+	select {
+	case <-ctx.Done():
+	case err = <-errs:
+	}
+
+	logging.Info("Shutting down", "timeout", timeout.String())
+
+	// A second signal kills the process without waiting for the requests
+	stop()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	for _, srv := range servers {
+		wg.Add(1)
+
+		go func(srv *http.Server) {
+			defer wg.Done()
+
+			if shutdownErr := srv.Shutdown(shutdownCtx); shutdownErr != nil {
+				mu.Lock()
+				defer mu.Unlock()
+
+				if err == nil {
+					err = fmt.Errorf("server: shutting down %s: %w", srv.Addr, shutdownErr)
+				}
+			}
+		}(srv)
+	}
+
+	wg.Wait()
+	return err
+}
@@ -0,0 +1,147 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+
+	"minitwit/config"
+	"minitwit/logging"
+)
+
+// freePort returns a port that was free a moment ago.
+func freePort(t *testing.T) int {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+// serveSlowly starts Run with a server whose handler reports that it started
+// and then waits for release before responding. It returns the error of Run
+// and the response of a request to it.
+func serveSlowly(t *testing.T, timeout time.Duration, release <-chan struct{}) (<-chan error, <-chan string) {
+	t.Helper()
+	logging.SetDefault(logging.New(io.Discard))
+
+	port := freePort(t)
+	started := make(chan struct{})
+	srv := New(port, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		io.WriteString(w, "done")
+	}), config.Default().Server)
+
+	ran := make(chan error, 1)
+	responses := make(chan string, 1)
+
+	go func() {
+		ran <- Run(timeout, srv)
+	}()
+
+	go func() {
+		for i := 0; i < 50; i++ {
+			resp, err := http.Get("http://127.0.0.1:" + strconv.Itoa(port))
+
+			if err != nil {
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			responses <- string(body)
+			return
+		}
+
+		responses <- "no response"
+	}()
+
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the request was not served")
+	}
+
+	process, _ := os.FindProcess(os.Getpid())
+
+	if err := process.Signal(syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+
+	return ran, responses
+}
+
+func TestNew(t *testing.T) {
+	cfg := config.ServerConfig{
+		ReadTimeout:  config.Duration(time.Second),
+		WriteTimeout: config.Duration(2 * time.Second),
+		IdleTimeout:  config.Duration(3 * time.Second),
+	}
+	srv := New(8080, http.NotFoundHandler(), cfg)
+
+	if srv.Addr != "0.0.0.0:8080" || srv.ReadTimeout != time.Second || srv.WriteTimeout != 2*time.Second || srv.IdleTimeout != 3*time.Second {
+		t.Errorf("got %+v", srv)
+	}
+}
+
+func TestRunFinishesInFlightRequests(t *testing.T) {
+	release := make(chan struct{})
+	ran, responses := serveSlowly(t, 5*time.Second, release)
+
+	// Give Run time to start shutting down before the request completes
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+
+	if err := <-ran; err != nil {
+		t.Errorf("Run() = %v, want nil after a signal", err)
+	}
+
+	if body := <-responses; body != "done" {
+		t.Errorf("got response %q, want done", body)
+	}
+}
+
+func TestRunGivesUpAfterTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	ran, _ := serveSlowly(t, 50*time.Millisecond, release)
+
+	if err := <-ran; !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Run() = %v, want the shutdown to time out", err)
+	}
+}
+
+func TestRunReturnsServeErrors(t *testing.T) {
+	logging.SetDefault(logging.New(io.Discard))
+
+	l, err := net.Listen("tcp", "0.0.0.0:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer l.Close()
+
+	port := l.Addr().(*net.TCPAddr).Port
+	other := New(freePort(t), http.NotFoundHandler(), config.Default().Server)
+	taken := New(port, http.NotFoundHandler(), config.Default().Server)
+
+	if err := Run(time.Second, other, taken); err == nil {
+		t.Error("Run() = nil with a port in use, want an error")
+	}
+}